@@ -0,0 +1,29 @@
+package global
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReplaceConfig(t *testing.T) {
+	_config.Store(&Config{Target: "redis", BulkSize: 100})
+	old := Cfg()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // 热加载期间读取配置的调用方
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_ = Cfg().BulkSize
+		}
+	}()
+	ReplaceConfig(&Config{Target: "kafka", BulkSize: 200})
+	wg.Wait()
+
+	if old.Target != "redis" || old.BulkSize != 100 {
+		t.Errorf("previous config modified: %+v", old)
+	}
+	if Cfg().Target != "kafka" || Cfg().BulkSize != 200 {
+		t.Errorf("config not replaced: %+v", Cfg())
+	}
+}
@@ -2,6 +2,7 @@ package global
 
 import (
 	"fmt"
+	"hash/crc32"
 	"log"
 	"runtime"
 	"strconv"
@@ -10,6 +11,7 @@ import (
 
 	sidlog "github.com/siddontang/go-log/log"
 	"go-mysql-transfer/util/logs"
+	"go-mysql-transfer/util/snowflake"
 )
 
 var (
@@ -53,10 +55,10 @@ func Initialize(configPath string) error {
 	if err := initConfig(configPath); err != nil {
 		return err
 	}
-	runtime.GOMAXPROCS(_config.Maxprocs)
+	runtime.GOMAXPROCS(Cfg().Maxprocs)
 
 	// 初始化global logger
-	if err := logs.Initialize(_config.LoggerConfig); err != nil {
+	if err := logs.Initialize(Cfg().LoggerConfig); err != nil {
 		return err
 	}
 
@@ -70,18 +72,30 @@ func Initialize(configPath string) error {
 	_bootTime = time.Now()
 	_pid = syscall.Getpid()
 
-	if _config.IsCluster(){
-		if _config.EnableWebAdmin {
-			_currentNode = _config.Cluster.BindIp + ":" + strconv.Itoa(_config.WebAdminPort)
+	if Cfg().IsCluster(){
+		if Cfg().EnableWebAdmin {
+			_currentNode = Cfg().Cluster.BindIp + ":" + strconv.Itoa(Cfg().WebAdminPort)
 		} else {
-			_currentNode = _config.Cluster.BindIp + ":" + strconv.Itoa(_pid)
+			_currentNode = Cfg().Cluster.BindIp + ":" + strconv.Itoa(_pid)
 		}
 	}
 
+	nodeId := snowflakeNodeId()
+	snowflake.InitSnowflake(nodeId)
+
 	log.Println(fmt.Sprintf("process id: %d", _pid))
-	log.Println(fmt.Sprintf("GOMAXPROCS :%d", _config.Maxprocs))
-	log.Println(fmt.Sprintf("source  %s(%s)", _config.Flavor, _config.Addr))
-	log.Println(fmt.Sprintf("destination %s", _config.Destination()))
+	log.Println(fmt.Sprintf("snowflake node id: %d", nodeId))
+	log.Println(fmt.Sprintf("GOMAXPROCS :%d", Cfg().Maxprocs))
+	log.Println(fmt.Sprintf("source  %s(%s)", Cfg().Flavor, Cfg().Addr))
+	log.Println(fmt.Sprintf("destination %s", Cfg().Destination()))
 
 	return nil
 }
+
+// snowflakeNodeId 雪花ID的节点标识，集群模式下由当前节点(IP:端口)计算，单机模式下取slave_id的低16位
+func snowflakeNodeId() uint16 {
+	if Cfg().IsCluster() {
+		return uint16(crc32.ChecksumIEEE([]byte(_currentNode)))
+	}
+	return uint16(Cfg().SlaveID)
+}
@@ -20,6 +20,8 @@ package global
 import (
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -46,6 +48,17 @@ const (
 	ValEncoderJson     = "json"
 	ValEncoderKVCommas = "kv-commas"
 	ValEncoderVCommas  = "v-commas"
+	ValEncoderAvro     = "avro"
+	ValEncoderProtobuf = "protobuf"
+
+	MessageFormatDefault  = "default"
+	MessageFormatDebezium = "debezium"
+	MessageFormatCanal    = "canal-json"
+	MessageFormatMaxwell  = "maxwell-json"
+	MessageFormatCloud    = "cloudevents"
+
+	CloudEventsStructured = "structured"
+	CloudEventsBinary     = "binary"
 )
 
 var (
@@ -73,6 +86,8 @@ type Rule struct {
 	ColumnMappingConfigs     string `yaml:"column_mappings"`            // 列名称映射
 	DefaultColumnValueConfig string `yaml:"default_column_values"`      // 默认的字段和值
 	// #值编码，支持json、kv-commas、v-commas；默认为json；json形如：{"id":123,"name":"wangjie"} 、kv-commas形如：id=123,name="wangjie"、v-commas形如：123,wangjie
+	// KAFKA、RABBITMQ、ROCKETMQ还支持avro，消息使用Confluent wire format编码，schema注册到schema_registry_addr；
+	// 以及protobuf，消息为proto/transport.proto中定义的ChangeEvent
 	ValueEncoder      string `yaml:"value_encoder"`
 	ValueFormatter    string `yaml:"value_formatter"`    //格式化定义key,{id}表示字段id的值、{name}表示字段name的值
 	LuaScript         string `yaml:"lua_script"`         //lua 脚本
@@ -82,6 +97,14 @@ type Rule struct {
 
 	ReserveRawData bool `yaml:"reserve_raw_data"` // 保留update之前的数据，针对KAFKA、RABBITMQ、ROCKETMQ有效
 
+	// 消息格式，针对KAFKA、RABBITMQ、ROCKETMQ、HTTP有效；支持default、debezium、canal-json、maxwell-json、cloudevents，默认为default
+	MessageFormat       string `yaml:"message_format"`
+	MessageSchemaEnable bool   `yaml:"message_schema_enable"` // 消息中携带schema描述，仅message_format为debezium时有效
+	// 消息头(rabbitmq为headers、rocketmq为properties)中携带schema、table、action、binlog位置、GTID、时间戳、行序号，针对KAFKA、RABBITMQ、ROCKETMQ有效
+	MessageHeaders bool `yaml:"message_headers"`
+	// CloudEvents的内容模式，支持structured、binary，默认为structured；binary模式下事件属性放入消息头(kafka需0.11及以上版本)
+	CloudEventsMode string `yaml:"cloudevents_mode"`
+
 	// ------------------- REDIS -----------------
 	//对应redis的5种数据类型 String、Hash(字典) 、List(列表) 、Set(集合)、Sorted Set(有序集合)
 	RedisStructure string `yaml:"redis_structure"`
@@ -195,6 +218,43 @@ func RuleInsList() []*Rule {
 	return list
 }
 
+// ReplaceRuleIns 整体替换规则实例，用于热加载
+func ReplaceRuleIns(rules map[string]*Rule) {
+	_lockOfRuleInsMap.Lock()
+	defer _lockOfRuleInsMap.Unlock()
+
+	_ruleInsMap = rules
+}
+
+// DiffRuleConfigs 按schema.table比较两组规则配置，返回新增、删除及修改的规则
+func DiffRuleConfigs(olds, news []*Rule) (added, removed, modified []string) {
+	oldMap := make(map[string]*Rule, len(olds))
+	for _, r := range olds {
+		oldMap[r.Schema+"."+r.Table] = r
+	}
+	newMap := make(map[string]*Rule, len(news))
+	for _, r := range news {
+		key := r.Schema + "." + r.Table
+		newMap[key] = r
+		old, ok := oldMap[key]
+		if !ok {
+			added = append(added, key)
+		} else if !reflect.DeepEqual(old, r) {
+			modified = append(modified, key)
+		}
+	}
+	for key := range oldMap {
+		if _, ok := newMap[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(modified)
+	return
+}
+
 func RuleKeyList() []string {
 	_lockOfRuleInsMap.RLock()
 	defer _lockOfRuleInsMap.RUnlock()
@@ -225,6 +285,10 @@ func (s *Rule) Initialize() error {
 		s.ValueEncoder = ""
 	}
 
+	if err := s.initMessageFormat(); err != nil {
+		return err
+	}
+
 	if s.DefaultColumnValueConfig != "" {
 		dm := make(map[string]string)
 		for _, t := range strings.Split(s.DefaultColumnValueConfig, ",") {
@@ -247,48 +311,54 @@ func (s *Rule) Initialize() error {
 		s.DatetimeFormatter = dates.ConvertGoFormat(s.DatetimeFormatter)
 	}
 
-	if _config.IsRedis() {
+	if Cfg().IsRedis() {
 		if err := s.initRedisConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsRocketmq() {
+	if Cfg().IsRocketmq() {
 		if err := s.initRocketConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsMongodb() {
+	if Cfg().IsMongodb() {
 		if err := s.initMongoConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsRabbitmq() {
+	if Cfg().IsRabbitmq() {
 		if err := s.initRabbitmqConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsKafka() {
+	if Cfg().IsKafka() {
 		if err := s.initKafkaConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsEls() {
+	if Cfg().IsEls() {
 		if err := s.initElsConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsScript() {
+	if Cfg().IsScript() {
 		if s.LuaScript == "" && s.LuaFilePath == "" {
 			return errors.New("empty lua script not allowed")
 		}
 	}
 
+	if Cfg().IsFile() || Cfg().IsParquet() || Cfg().IsS3() || Cfg().IsGrpc() || Cfg().IsHttp() {
+		if s.LuaEnable() {
+			return errors.Errorf("lua script not supported by %s target", Cfg().DestStdName())
+		}
+	}
+
 	return nil
 }
 
@@ -297,43 +367,43 @@ func (s *Rule) AfterUpdateTableInfo() error {
 		return err
 	}
 
-	if _config.IsRedis() {
+	if Cfg().IsRedis() {
 		if err := s.initRedisConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsRocketmq() {
+	if Cfg().IsRocketmq() {
 		if err := s.initRocketConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsMongodb() {
+	if Cfg().IsMongodb() {
 		if err := s.initMongoConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsRabbitmq() {
+	if Cfg().IsRabbitmq() {
 		if err := s.initRabbitmqConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsKafka() {
+	if Cfg().IsKafka() {
 		if err := s.initKafkaConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsEls() {
+	if Cfg().IsEls() {
 		if err := s.initElsConfig(); err != nil {
 			return err
 		}
 	}
 
-	if _config.IsScript() {
+	if Cfg().IsScript() {
 		if s.LuaScript == "" || s.LuaFilePath == "" {
 			return errors.New("empty lua script not allowed")
 		}
@@ -617,6 +687,48 @@ func (s *Rule) initKafkaConfig() error {
 	return nil
 }
 
+func (s *Rule) initMessageFormat() error {
+	if s.MessageFormat == "" {
+		s.MessageFormat = MessageFormatDefault
+	}
+	s.MessageFormat = strings.ToLower(s.MessageFormat)
+
+	switch s.MessageFormat {
+	case MessageFormatDefault, MessageFormatDebezium, MessageFormatCanal, MessageFormatMaxwell, MessageFormatCloud:
+	default:
+		return errors.Errorf("unsupported message_format: %s", s.MessageFormat)
+	}
+
+	if s.MessageFormat == MessageFormatCloud {
+		if s.CloudEventsMode == "" {
+			s.CloudEventsMode = CloudEventsStructured
+		}
+		s.CloudEventsMode = strings.ToLower(s.CloudEventsMode)
+		if !(s.CloudEventsMode == CloudEventsStructured || s.CloudEventsMode == CloudEventsBinary) {
+			return errors.Errorf("unsupported cloudevents_mode: %s", s.CloudEventsMode)
+		}
+	}
+
+	if s.MessageHeaders && !Cfg().IsMQ() {
+		return errors.New("message_headers only supported by kafka、rocketmq、rabbitmq")
+	}
+
+	if s.ValueEncoder == ValEncoderAvro || s.ValueEncoder == ValEncoderProtobuf {
+		if !Cfg().IsMQ() {
+			return errors.Errorf("value_encoder %s only supported by kafka、rocketmq、rabbitmq", s.ValueEncoder)
+		}
+		if s.MessageFormat != MessageFormatDefault {
+			return errors.Errorf("value_encoder %s not supported by message_format %s", s.ValueEncoder, s.MessageFormat)
+		}
+	}
+
+	if s.ValueEncoder == ValEncoderAvro && Cfg().SchemaRegistryAddr == "" {
+		return errors.New("empty schema_registry_addr not allowed when value_encoder is avro")
+	}
+
+	return nil
+}
+
 // 编译Lua
 func (s *Rule) CompileLuaScript(dataDir string) error {
 	script := s.LuaScript
@@ -640,7 +752,7 @@ func (s *Rule) CompileLuaScript(dataDir string) error {
 
 	s.LuaScript = script
 
-	if _config.IsRedis() {
+	if Cfg().IsRedis() {
 		if !strings.Contains(script, `require("redisOps")`) {
 			return errors.New("lua script incorrect format")
 		}
@@ -660,7 +772,7 @@ func (s *Rule) CompileLuaScript(dataDir string) error {
 		}
 	}
 
-	if _config.IsRocketmq() {
+	if Cfg().IsRocketmq() {
 		if !strings.Contains(script, `require("mqOps")`) {
 			return errors.New("lua script incorrect format")
 		}
@@ -670,7 +782,7 @@ func (s *Rule) CompileLuaScript(dataDir string) error {
 		}
 	}
 
-	if _config.IsEls() {
+	if Cfg().IsEls() {
 		if !strings.Contains(script, `require("esOps")`) {
 			return errors.New("lua script incorrect format")
 		}
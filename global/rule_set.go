@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package global
+
+import (
+	"sort"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// 规则集中不允许出现的配置项：数据源、进程及集群相关的配置只能在各节点的本地配置文件中设置
+var _ruleSetForbiddenKeys = map[string]bool{
+	"target":              true,
+	"addr":                true,
+	"user":                true,
+	"pass":                true,
+	"charset":             true,
+	"slave_id":            true,
+	"flavor":              true,
+	"data_dir":            true,
+	"server_name":         true,
+	"mysqldump":           true,
+	"skip_master_data":    true,
+	"mysql_tls":           true,
+	"maxprocs":            true,
+	"rule_file":           true,
+	"rule_watch_interval": true,
+	"logger":              true,
+	"enable_exporter":     true,
+	"exporter_addr":       true,
+	"enable_web_admin":    true,
+	"web_admin_port":      true,
+	"cluster":             true,
+}
+
+// ParseRuleSet 将集群中保存的规则集叠加到本地配置之上，返回校验通过的新配置
+// 规则集为YAML格式，包含rule及接收端相关的配置项，格式与主配置文件相同
+func ParseRuleSet(ruleSet []byte) (*Config, error) {
+	if _configData == nil {
+		return nil, errors.New("config not initialized")
+	}
+	c, err := parseConfig(_configData, ruleSet)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return c, nil
+}
+
+func overlayRuleSet(c *Config, ruleSet []byte) error {
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(ruleSet, &keys); err != nil {
+		return errors.Trace(err)
+	}
+	var forbidden []string
+	for key := range keys {
+		if _ruleSetForbiddenKeys[key] {
+			forbidden = append(forbidden, key)
+		}
+	}
+	if len(forbidden) > 0 {
+		sort.Strings(forbidden)
+		return errors.Errorf("rule set must not contain %v, set them in the local config", forbidden)
+	}
+	if _, ok := keys["rule"]; !ok {
+		return errors.New("rule set must contain rule")
+	}
+
+	// 环境变量由parseConfig在叠加之后统一替换
+	c.RuleConfigs = nil
+	return errors.Trace(yaml.Unmarshal(ruleSet, c))
+}
@@ -0,0 +1,59 @@
+package global
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRuleConfigs(t *testing.T) {
+	olds := []*Rule{
+		{Schema: "eseap", Table: "t_user"},
+		{Schema: "eseap", Table: "t_order", RedisStructure: "string"},
+		{Schema: "eseap", Table: "t_log"},
+	}
+	news := []*Rule{
+		{Schema: "eseap", Table: "t_user"},
+		{Schema: "eseap", Table: "t_order", RedisStructure: "hash"},
+		{Schema: "eseap", Table: "t_item"},
+	}
+
+	added, removed, modified := DiffRuleConfigs(olds, news)
+	if !reflect.DeepEqual(added, []string{"eseap.t_item"}) {
+		t.Errorf("added: %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"eseap.t_log"}) {
+		t.Errorf("removed: %v", removed)
+	}
+	if !reflect.DeepEqual(modified, []string{"eseap.t_order"}) {
+		t.Errorf("modified: %v", modified)
+	}
+}
+
+func TestOverlayRuleSet(t *testing.T) {
+	c := &Config{
+		Target:      "redis",
+		Addr:        "127.0.0.1:3306",
+		RedisAddr:   "127.0.0.1:6379",
+		RuleConfigs: []*Rule{{Schema: "eseap", Table: "t_user"}},
+	}
+	ruleSet := []byte("redis_addrs: 10.0.0.1:6379\n" +
+		"rule:\n" +
+		"  - schema: eseap\n" +
+		"    table: t_order\n")
+	if err := overlayRuleSet(c, ruleSet); err != nil {
+		t.Fatal(err)
+	}
+	if c.RedisAddr != "10.0.0.1:6379" || c.Addr != "127.0.0.1:3306" {
+		t.Errorf("unexpected target settings: %s %s", c.RedisAddr, c.Addr)
+	}
+	if len(c.RuleConfigs) != 1 || c.RuleConfigs[0].Table != "t_order" {
+		t.Errorf("rules not replaced: %v", c.RuleConfigs)
+	}
+
+	if err := overlayRuleSet(c, []byte("addr: 10.0.0.2:3306\nrule:\n  - schema: eseap\n")); err == nil {
+		t.Error("expected error for source settings")
+	}
+	if err := overlayRuleSet(c, []byte("redis_addrs: 10.0.0.1:6379\n")); err == nil {
+		t.Error("expected error without rules")
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package global
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+const (
+	SecretKeyEnv = "TRANSFER_SECRET_KEY" // 未通过-secret-key指定时，从此环境变量读取密钥
+
+	_secretFilePrefix      = "file:"
+	_secretEncryptedPrefix = "enc:"
+)
+
+// ${VAR}或${VAR:-default}，$${VAR}转义为字面量${VAR}
+var _envPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+var _secretKey string
+
+// SetSecretKey 设置解密enc:配置值的密钥，需在Initialize之前调用
+func SetSecretKey(key string) {
+	_secretKey = key
+}
+
+func secretKey() string {
+	if _secretKey != "" {
+		return _secretKey
+	}
+	return os.Getenv(SecretKeyEnv)
+}
+
+// expandEnv 替换配置值中的环境变量引用
+func expandEnv(value string) (string, error) {
+	var err error
+	expanded := _envPattern.ReplaceAllStringFunc(value, func(m string) string {
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+		sub := _envPattern.FindStringSubmatch(m)
+		if v, ok := os.LookupEnv(sub[1]); ok {
+			return v
+		}
+		if sub[2] != "" {
+			return sub[3]
+		}
+		if err == nil {
+			err = errors.Errorf("environment variable %s not set", sub[1])
+		}
+		return m
+	})
+	return expanded, err
+}
+
+// expandEnvFields 在yaml解析之后替换配置中各字符串字段的环境变量引用，
+// 变量值不参与yaml解析，其中包含的引号、#、换行等字符原样保留
+func expandEnvFields(v interface{}) error {
+	return expandEnvValue(reflect.ValueOf(v))
+}
+
+func expandEnvValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return expandEnvValue(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() || !v.CanSet() {
+			return nil
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := expandEnvValue(elem); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			// 只处理yaml中的配置项
+			tag := t.Field(i).Tag.Get("yaml")
+			if t.Field(i).PkgPath != "" || tag == "" || tag == "-" {
+				continue
+			}
+			if err := expandEnvValue(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandEnvValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := expandEnvValue(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		expanded, err := expandEnv(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(expanded)
+	}
+	return nil
+}
+
+// resolveSecret 解析file:引用及enc:加密值，其他值原样返回
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, _secretFilePrefix):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(value, _secretFilePrefix))
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, _secretEncryptedPrefix):
+		key := secretKey()
+		if key == "" {
+			return "", errors.Errorf("secret key required for encrypted value, use -secret-key or %s", SecretKeyEnv)
+		}
+		return DecryptSecret(value, key)
+	}
+	return value, nil
+}
+
+// resolveSecrets 解析配置中的密码类字段
+func resolveSecrets(c *Config) error {
+	fields := map[string]*string{
+		"pass":                     &c.Password,
+		"redis_pass":               &c.RedisPass,
+		"mongodb_password":         &c.MongodbPassword,
+		"rabbitmq_addr":            &c.RabbitmqAddr,
+		"kafka_sasl_password":      &c.KafkaSASLPassword,
+		"rocketmq_secret_key":      &c.RocketmqSecretKey,
+		"es_password":              &c.ElsPassword,
+		"schema_registry_password": &c.SchemaRegistryPassword,
+		"s3_secret_key":            &c.S3SecretKey,
+		"grpc_token":               &c.GrpcToken,
+		"web_admin_token":          &c.WebAdminToken,
+	}
+	if c.Cluster != nil {
+		fields["etcd_password"] = &c.Cluster.EtcdPassword
+		fields["zk_authentication"] = &c.Cluster.ZkAuthentication
+	}
+
+	for name, field := range fields {
+		value, err := resolveSecret(*field)
+		if err != nil {
+			return errors.Annotatef(err, "resolve %s", name)
+		}
+		*field = value
+	}
+	return nil
+}
+
+func secretCipher(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret 使用AES-GCM加密，返回可直接写入配置文件的enc:值
+func EncryptSecret(plaintext, key string) (string, error) {
+	aead, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Trace(err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return _secretEncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptSecret(value, key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, _secretEncryptedPrefix))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	aead, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("encrypted value too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("decrypt failed, wrong secret key or corrupted value")
+	}
+	return string(plaintext), nil
+}
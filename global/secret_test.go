@@ -0,0 +1,98 @@
+package global
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("TRANSFER_TEST_PASS", "s3cret")
+	defer os.Unsetenv("TRANSFER_TEST_PASS")
+
+	cases := map[string]string{
+		"${TRANSFER_TEST_PASS}":       "s3cret",
+		"${TRANSFER_TEST_USER:-root}": "root",
+		"$${TRANSFER_TEST_PASS}":      "${TRANSFER_TEST_PASS}",
+		"plain":                       "plain",
+	}
+	for in, want := range cases {
+		if out, err := expandEnv(in); err != nil || out != want {
+			t.Errorf("%s: want %q, got %q %v", in, want, out, err)
+		}
+	}
+
+	if _, err := expandEnv("${TRANSFER_TEST_UNSET}"); err == nil {
+		t.Error("expected error for unset variable")
+	}
+}
+
+func TestExpandEnvFields(t *testing.T) {
+	// 变量值中包含yaml特殊字符时不能截断或破坏配置
+	secret := "\"p #w: d'\nline2"
+	os.Setenv("TRANSFER_TEST_PASS", secret)
+	defer os.Unsetenv("TRANSFER_TEST_PASS")
+
+	data := []byte("pass: ${TRANSFER_TEST_PASS}\n" +
+		"redis_pass: \"${TRANSFER_TEST_PASS}\"\n" +
+		"#es_password: ${TRANSFER_TEST_UNSET}\n" +
+		"cluster:\n" +
+		"  etcd_password: ${TRANSFER_TEST_PASS}\n" +
+		"rule:\n" +
+		"  - schema: ${TRANSFER_TEST_SCHEMA:-shop}\n" +
+		"    lua_script: $${TRANSFER_TEST_PASS}\n")
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := expandEnvFields(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Password != secret || c.RedisPass != secret || c.Cluster.EtcdPassword != secret {
+		t.Errorf("secret changed: %q %q %q", c.Password, c.RedisPass, c.Cluster.EtcdPassword)
+	}
+	if c.ElsPassword != "" {
+		t.Errorf("commented line expanded: %q", c.ElsPassword)
+	}
+	if c.RuleConfigs[0].Schema != "shop" || c.RuleConfigs[0].LuaScript != "${TRANSFER_TEST_PASS}" {
+		t.Errorf("unexpected rule: %q %q", c.RuleConfigs[0].Schema, c.RuleConfigs[0].LuaScript)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := resolveSecret("file:" + path); err != nil || v != "from-file" {
+		t.Errorf("file reference: %q %v", v, err)
+	}
+
+	encrypted, err := EncryptSecret("from-enc", "key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSecretKey("key-1")
+	if v, err := resolveSecret(encrypted); err != nil || v != "from-enc" {
+		t.Errorf("encrypted value: %q %v", v, err)
+	}
+	SetSecretKey("key-2")
+	if _, err := resolveSecret(encrypted); err == nil {
+		t.Error("expected error with wrong key")
+	}
+	SetSecretKey("")
+
+	if v, _ := resolveSecret("plain"); v != "plain" {
+		t.Errorf("plain value changed: %q", v)
+	}
+}
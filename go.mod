@@ -5,15 +5,18 @@ go 1.14
 require (
 	github.com/Shopify/sarama v1.27.0
 	github.com/apache/rocketmq-client-go/v2 v2.0.0
+	github.com/aws/aws-sdk-go v1.33.5
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-gomail/gomail v0.0.0-20160411212932-81ebce5c23df
 	github.com/go-redis/redis v6.15.8+incompatible
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/golang/protobuf v1.4.2
 	github.com/jmoiron/sqlx v1.2.0 // indirect
 	github.com/json-iterator/go v1.1.9
 	github.com/juju/errors v0.0.0-20200330140219-3fe23663418f
 	github.com/juju/testing v0.0.0-20200706033705-4c23f9c453cd // indirect
 	github.com/layeh/gopher-json v0.0.0-20190114024228-97fed8db8427
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/olivere/elastic v6.2.34+incompatible
 	github.com/olivere/elastic/v7 v7.0.19
 	github.com/onsi/ginkgo v1.14.0 // indirect
@@ -26,15 +29,23 @@ require (
 	github.com/satori/go.uuid v1.2.0
 	github.com/siddontang/go-log v0.0.0-20180807004314-8d05993dda07
 	github.com/siddontang/go-mysql v1.1.0
+	github.com/sony/sonyflake v1.0.0
 	github.com/streadway/amqp v1.0.0
 	github.com/vmihailenco/msgpack v4.0.4+incompatible
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c
+	github.com/xitongsys/parquet-go v1.5.4
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	github.com/yuin/gopher-lua v0.0.0-20200603152657-dc2b0ca8b37e
 	go.etcd.io/bbolt v1.3.3
 	go.etcd.io/etcd v0.5.0-alpha.5.0.20191023171146-3cf2f69b5738
 	go.mongodb.org/mongo-driver v1.4.0
 	go.uber.org/atomic v1.6.0
 	go.uber.org/zap v1.15.0
+	google.golang.org/grpc v1.27.1
+	google.golang.org/protobuf v1.23.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/yaml.v2 v2.3.0
-	github.com/sony/sonyflake v1.0.0
 )
+
+// etcd v3.4 clientv3 is incompatible with grpc >= 1.27
+replace google.golang.org/grpc => google.golang.org/grpc v1.26.0
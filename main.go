@@ -18,12 +18,18 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/juju/errors"
@@ -32,7 +38,10 @@ import (
 	"go-mysql-transfer/global"
 	"go-mysql-transfer/metrics"
 	"go-mysql-transfer/service"
+	"go-mysql-transfer/service/pipeline"
 	"go-mysql-transfer/storage"
+	"go-mysql-transfer/tracing"
+	"go-mysql-transfer/util/logs"
 	"go-mysql-transfer/util/stringutil"
 	"go-mysql-transfer/web"
 )
@@ -43,6 +52,18 @@ var (
 	stockFlag    bool
 	positionFlag bool
 	statusFlag   bool
+	secretKey    string
+	encryptFlag  bool
+	validateFlag bool
+	publishRules string
+	rollbackFlag bool
+	listRules    bool
+	ruleVersion  int
+	pipelines    string
+	pipelineName string
+	verifyFlag   bool
+	verifyTables string
+	repairFlag   bool
 )
 
 func init() {
@@ -51,6 +72,18 @@ func init() {
 	flag.BoolVar(&stockFlag, "stock", false, "stock data import")
 	flag.BoolVar(&positionFlag, "position", false, "set dump position")
 	flag.BoolVar(&statusFlag, "status", false, "display application status")
+	flag.StringVar(&secretKey, "secret-key", "", "key for decrypting enc: values in config, default env "+global.SecretKeyEnv)
+	flag.BoolVar(&encryptFlag, "encrypt", false, "encrypt a value read from stdin with the secret key")
+	flag.BoolVar(&validateFlag, "validate", false, "validate config and rules against MySQL without starting replication")
+	flag.StringVar(&publishRules, "publish-rules", "", "publish a rule set file to the cluster, requires cluster.central_rules")
+	flag.BoolVar(&rollbackFlag, "rollback-rules", false, "switch the cluster to the previous rule version, or to -rule-version")
+	flag.BoolVar(&listRules, "list-rules", false, "list rule versions in the cluster, or print the rule set of -rule-version")
+	flag.IntVar(&ruleVersion, "rule-version", 0, "rule version for -rollback-rules and -list-rules")
+	flag.StringVar(&pipelines, "pipelines", "", "pipelines config file, run each pipeline as a child process")
+	flag.StringVar(&pipelineName, "pipeline", "", "pipeline name, set by -pipelines for child processes")
+	flag.BoolVar(&verifyFlag, "verify", false, "verify that the target matches MySQL, report missing, extra and differing rows")
+	flag.StringVar(&verifyTables, "verify-tables", "", "tables to verify, like schema.table separated by commas, default all rules")
+	flag.BoolVar(&repairFlag, "repair", false, "rewrite missing and differing rows found by -verify to the target")
 	flag.Usage = usage
 }
 
@@ -89,18 +122,44 @@ func main() {
 		return
 	}
 
+	global.SetSecretKey(secretKey)
+	if encryptFlag {
+		doEncrypt()
+		return
+	}
+
+	if pipelines != "" {
+		doPipelines()
+		return
+	}
+
 	// 初始化global
+	global.SetPipeline(pipelineName)
 	err := global.Initialize(cfgPath)
 	if err != nil {
 		println(errors.ErrorStack(err))
 		return
 	}
 
+	if validateFlag {
+		if !doValidate() {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if stockFlag {
 		doStock()
 		return
 	}
 
+	if verifyFlag {
+		if !doVerify() {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 初始化Storage
 	err = storage.Initialize()
 	if err != nil {
@@ -118,12 +177,23 @@ func main() {
 		return
 	}
 
+	if publishRules != "" || rollbackFlag || listRules {
+		doRules()
+		return
+	}
+
+	if err := initTracing(); err != nil {
+		println(errors.ErrorStack(err))
+		return
+	}
+
 	err = service.Initialize()
 	if err != nil {
 		println(errors.ErrorStack(err))
 		return
 	}
 
+	web.RegisterHealth()
 	if err := metrics.Initialize(); err != nil {
 		println(errors.ErrorStack(err))
 		return
@@ -135,6 +205,31 @@ func main() {
 	}
 	service.StartUp() // start application
 
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			log.Println("reload rules, signal: SIGHUP")
+			if err := service.ReloadRules(); err != nil {
+				logs.Errorf("reload rules: %s", errors.ErrorStack(err))
+			}
+		}
+	}()
+
+	pause := make(chan os.Signal, 1)
+	notifyPauseSignals(pause)
+	go func() {
+		for sig := range pause {
+			if isPauseSignal(sig) {
+				log.Printf("pause transfer, signal: %s \n", sig.String())
+				service.TransferServiceIns().Pause()
+			} else {
+				log.Printf("resume transfer, signal: %s \n", sig.String())
+				service.TransferServiceIns().Resume()
+			}
+		}
+	}()
+
 	s := make(chan os.Signal, 1)
 	signal.Notify(s, os.Kill, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	sin := <-s
@@ -142,6 +237,7 @@ func main() {
 
 	web.Close()
 	service.Close()
+	tracing.Close()
 	storage.Close()
 }
 
@@ -153,12 +249,221 @@ func doStock() {
 	stock.Close()
 }
 
+// initTracing 开启链路追踪，资源属性标识数据源及管道
+func initTracing() error {
+	c := global.Cfg()
+	if !c.EnableTracing {
+		return nil
+	}
+	attributes := map[string]string{
+		"transfer.source": c.ServerName,
+		"transfer.target": c.Target,
+	}
+	if global.Pipeline() != "" {
+		attributes["transfer.pipeline"] = global.Pipeline()
+	}
+	return tracing.Initialize(tracing.Options{
+		Endpoint:    c.TracingEndpoint,
+		Headers:     c.TracingHeaders,
+		SampleRatio: c.TracingSampleRatio,
+		ServiceName: "go-mysql-transfer",
+		Attributes:  attributes,
+	})
+}
+
+func doVerify() bool {
+	var tables []string
+	if verifyTables != "" {
+		tables = strings.Split(verifyTables, ",")
+	}
+
+	verify := service.NewVerifyService(repairFlag)
+	defer verify.Close()
+	reports, err := verify.Run(tables)
+	if err != nil {
+		println(errors.ErrorStack(err))
+		return false
+	}
+
+	passed := true
+	for _, report := range reports {
+		status := "OK"
+		if !report.Ok() {
+			status = "FAIL"
+			passed = false
+		}
+		fmt.Printf("[%s] %s.%s -> %s\n", status, report.Schema, report.Table, global.Cfg().DestStdName())
+		if report.Err != nil {
+			fmt.Printf("    error: %s\n", report.Err.Error())
+			continue
+		}
+		extra := "not checked"
+		if report.ExtraChecked {
+			extra = strconv.FormatInt(report.Extra, 10)
+			if report.ExtraCounted {
+				extra += " (count only, too many rows to list keys)"
+			}
+		}
+		fmt.Printf("    rows: %d, missing: %d, different: %d, extra: %s, repaired: %d\n",
+			report.Rows, report.Missing, report.Different, extra, report.Repaired)
+		for _, kind := range []string{service.VerifyMissing, service.VerifyDifferent, service.VerifyExtra} {
+			if keys := report.Samples[kind]; len(keys) > 0 {
+				fmt.Printf("    %s: %s\n", kind, strings.Join(keys, ", "))
+			}
+		}
+	}
+	return passed
+}
+
+func doValidate() bool {
+	results, err := service.Validate()
+	if err != nil {
+		println(errors.ErrorStack(err))
+		return false
+	}
+
+	passed := true
+	for _, result := range results {
+		status := "OK"
+		if !result.Ok() {
+			status = "FAIL"
+			passed = false
+		}
+		fmt.Printf("[%s] rule %s.%s -> %s\n", status, result.Schema, result.Table, global.Cfg().DestStdName())
+		if result.Err != nil {
+			fmt.Printf("    error: %s\n", result.Err.Error())
+		}
+
+		tables := make([]string, 0, len(result.Tables))
+		for table := range result.Tables {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			if err := result.Tables[table]; err != nil {
+				fmt.Printf("    %s: %s\n", table, err.Error())
+			} else {
+				fmt.Printf("    %s: ok\n", table)
+			}
+		}
+	}
+
+	if passed {
+		fmt.Printf("%d rules validated\n", len(results))
+	}
+	return passed
+}
+
+func doRules() {
+	switch {
+	case publishRules != "":
+		data, err := ioutil.ReadFile(publishRules)
+		if err != nil {
+			println(errors.ErrorStack(err))
+			return
+		}
+		version, err := service.PublishRuleSet(data)
+		if err != nil {
+			println(errors.ErrorStack(err))
+			return
+		}
+		fmt.Printf("rule version %d published\n", version)
+	case rollbackFlag:
+		version, err := service.RollbackRuleSet(ruleVersion)
+		if err != nil {
+			println(errors.ErrorStack(err))
+			return
+		}
+		fmt.Printf("the current rule version is : %d\n", version)
+	case ruleVersion != 0:
+		data, err := service.RuleSet(ruleVersion)
+		if err != nil {
+			println(errors.ErrorStack(err))
+			return
+		}
+		fmt.Println(string(data))
+	default:
+		versions, current, err := service.RuleSetVersions()
+		if err != nil {
+			println(errors.ErrorStack(err))
+			return
+		}
+		for _, v := range versions {
+			if v == current {
+				fmt.Printf("%d (current)\n", v)
+			} else {
+				fmt.Printf("%d\n", v)
+			}
+		}
+	}
+}
+
+func doPipelines() {
+	m, err := pipeline.NewManager(pipelines, secretKey)
+	if err != nil {
+		println(errors.ErrorStack(err))
+		return
+	}
+	if err := web.StartPipelineAdmin(m); err != nil {
+		println(errors.ErrorStack(err))
+		return
+	}
+	if err := m.Start(); err != nil {
+		println(errors.ErrorStack(err))
+		m.Close()
+		return
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			log.Println("reload pipelines, signal: SIGHUP")
+			if err := m.Reload(); err != nil {
+				log.Println(errors.ErrorStack(err))
+			}
+		}
+	}()
+
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, os.Kill, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	sin := <-s
+	log.Printf("application stoped，signal: %s \n", sin.String())
+
+	web.Close()
+	m.Close()
+}
+
 func doStatus() {
 	ps := storage.NewPositionStorage()
 	pos, _ := ps.Get()
 	fmt.Printf("The current dump position is : %s %d \n", pos.Name, pos.Pos)
 }
 
+func doEncrypt() {
+	key := secretKey
+	if key == "" {
+		key = os.Getenv(global.SecretKeyEnv)
+	}
+	if key == "" {
+		println("error: please input the secret key by -secret-key or " + global.SecretKeyEnv)
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		println(errors.ErrorStack(err))
+		return
+	}
+	value, err := global.EncryptSecret(strings.TrimRight(line, "\r\n"), key)
+	if err != nil {
+		println(errors.ErrorStack(err))
+		return
+	}
+	fmt.Println(value)
+}
+
 func doPosition() {
 	others := flag.Args()
 	if len(others) != 2 {
@@ -20,6 +20,7 @@ package metrics
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -89,12 +90,54 @@ var (
 			Help: "The number of data deleted from destination",
 		}, []string{"table"},
 	)
+
+	latencyHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "transfer_latency_seconds",
+			Help:    "The end-to-end latency from the binlog event to the destination",
+			Buckets: []float64{0.5, 1, 2, 5, 10, 30, 60, 300, 900, 3600},
+		}, []string{"table", "target"},
+	)
+
+	batchSizeHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "transfer_batch_size",
+			Help:    "The number of rows consumed by the destination in one batch",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{"target"},
+	)
+
+	consumeHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "transfer_consume_duration_seconds",
+			Help:    "The time spent by the destination consuming one batch",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"target"},
+	)
+
+	heartbeatDelayGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "transfer_heartbeat_delay_seconds",
+			Help: "The time since the last heartbeat written to the heartbeat table reached the destination",
+		},
+	)
+
+	queueDepthGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "transfer_queue_depth",
+			Help: "The number of binlog events waiting in the handler queue",
+		},
+	)
 )
 
 func Initialize() error {
 	if global.Cfg().EnableExporter {
+		handler, err := exporterHandler()
+		if err != nil {
+			return err
+		}
 		go func() {
-			http.Handle("/", promhttp.Handler())
+			http.Handle("/", handler)
 			http.ListenAndServe(fmt.Sprintf(":%d", global.Cfg().ExporterPort), nil)
 		}()
 	}
@@ -111,6 +154,37 @@ func Initialize() error {
 	return nil
 }
 
+// exporterHandler 以管道子进程运行时，所有指标附加pipeline标签
+func exporterHandler() (http.Handler, error) {
+	if global.Pipeline() == "" {
+		return promhttp.Handler(), nil
+	}
+
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"pipeline": global.Pipeline()}, registry)
+	collectors := []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		leaderStateGauge,
+		destStateGauge,
+		delayGauge,
+		insertCounter,
+		updateCounter,
+		deleteCounter,
+		latencyHistogram,
+		batchSizeHistogram,
+		consumeHistogram,
+		heartbeatDelayGauge,
+		queueDepthGauge,
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
+}
+
 func SetLeaderState(state int) {
 	if global.Cfg().EnableExporter {
 		leaderStateGauge.Set(float64(state))
@@ -137,8 +211,40 @@ func SetTransferDelay(d uint32) {
 	if global.Cfg().EnableExporter {
 		delayGauge.Set(float64(d))
 	}
-	if global.Cfg().EnableWebAdmin {
-		delay.Store(d)
+	delay.Store(d)
+}
+
+// TransferDelay 同步延迟(秒)
+func TransferDelay() uint32 {
+	return delay.Load()
+}
+
+// SetHeartbeatDelay 根据心跳表计算的同步延迟，同时更新transfer_delay
+func SetHeartbeatDelay(d time.Duration) {
+	if global.Cfg().EnableExporter {
+		heartbeatDelayGauge.Set(d.Seconds())
+	}
+	SetTransferDelay(uint32(d / time.Second))
+}
+
+// ObserveLatency 记录一行数据从写入binlog到写入接收端的耗时(秒)
+func ObserveLatency(lab string, seconds float64) {
+	if global.Cfg().EnableExporter {
+		latencyHistogram.WithLabelValues(lab, global.Cfg().Target).Observe(seconds)
+	}
+}
+
+// ObserveConsume 记录接收端一次批量写入的行数及耗时
+func ObserveConsume(size int, d time.Duration) {
+	if global.Cfg().EnableExporter {
+		batchSizeHistogram.WithLabelValues(global.Cfg().Target).Observe(float64(size))
+		consumeHistogram.WithLabelValues(global.Cfg().Target).Observe(d.Seconds())
+	}
+}
+
+func SetQueueDepth(n int) {
+	if global.Cfg().EnableExporter {
+		queueDepthGauge.Set(float64(n))
 	}
 }
 
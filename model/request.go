@@ -1,6 +1,10 @@
 package model
 
-import "sync"
+import (
+	"sync"
+
+	"go-mysql-transfer/tracing"
+)
 
 var RowRequestPool = sync.Pool{
 	New: func() interface{} {
@@ -12,8 +16,19 @@ type RowRequest struct {
 	RuleKey   string
 	Action    string
 	Timestamp uint32
+	LogName   string // binlog文件名，全量同步时为空
+	LogPos    uint32 // 事件结束位置
+	ServerId  uint32 // 产生事件的MySQL server_id
+	Gtid      string // 所在事务的GTID，未开启GTID时为空
+	RowIndex  int    // 行在binlog事件中的序号
 	Old       []interface{}
 	Row       []interface{}
+	Trace     tracing.SpanContext // 读取binlog事件的span，未开启链路追踪时为空
+}
+
+// HeartbeatRequest 从binlog中读取到的心跳，Timestamp为写入心跳表的时间(毫秒)
+type HeartbeatRequest struct {
+	Timestamp int64
 }
 
 type PosRequest struct {
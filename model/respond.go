@@ -27,12 +27,13 @@ var redisRespondPool = sync.Pool{
 }
 
 type MQRespond struct {
-	Topic     string      `json:"-"`
-	Action    string      `json:"action"`
-	Timestamp uint32      `json:"timestamp"`
-	Raw       interface{} `json:"raw,omitempty"`
-	Date      interface{} `json:"date"`
-	ByteArray []byte      `json:"-"`
+	Topic     string            `json:"-"`
+	Action    string            `json:"action"`
+	Timestamp uint32            `json:"timestamp"`
+	Raw       interface{}       `json:"raw,omitempty"`
+	Date      interface{}       `json:"date"`
+	ByteArray []byte            `json:"-"`
+	Headers   map[string]string `json:"-"` // Lua脚本中设置的自定义消息头
 }
 
 type ESRespond struct {
@@ -0,0 +1,23 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package transport
+
+// transport.pb.go由proto/transport.proto生成，请勿手工修改。
+// grpc被固定在v1.26(etcd clientv3不兼容更高版本)，protoc-gen-go-grpc生成的代码需要grpc>=1.32，
+// 因此gRPC代码使用protoc-gen-go的grpc插件生成，且客户端使用*grpc.ClientConn、SupportPackageIsVersion4
+//go:generate protoc -I .. --go_out=plugins=grpc,paths=source_relative:. ../transport.proto
@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+func testBatch() *ChangeBatch {
+	return &ChangeBatch{
+		BatchId: 42,
+		Token:   "secret",
+		Events: []*ChangeEvent{
+			{
+				Schema:    "shop",
+				Table:     "t_user",
+				Action:    "update",
+				Timestamp: 1602806400,
+				Position:  &Position{Name: "mysql-bin.000003", Pos: 1234, ServerId: 1},
+				Columns: []*Column{
+					{Name: "id", MysqlType: "bigint(20)", Type: 1, Primary: true},
+					{Name: "name", MysqlType: "varchar(64)", Type: 5},
+					{Name: "score", MysqlType: "decimal(10,2)", Type: 3},
+					{Name: "avatar", MysqlType: "blob", Type: 9},
+				},
+				Before: &RowImage{Values: []*Value{
+					{Kind: &Value_IntValue{IntValue: -7}},
+					{Kind: &Value_StringValue{StringValue: "tom"}},
+					{Kind: &Value_DoubleValue{DoubleValue: 9.5}},
+					{Kind: &Value_NullValue{NullValue: true}},
+				}},
+				After: &RowImage{Values: []*Value{
+					{Kind: &Value_IntValue{IntValue: -7}},
+					{Kind: &Value_StringValue{StringValue: ""}},
+					{Kind: &Value_DoubleValue{DoubleValue: 0}},
+					{Kind: &Value_BytesValue{BytesValue: []byte{0, 1, 2}}},
+				}},
+			},
+		},
+	}
+}
+
+func TestChangeBatchRoundTrip(t *testing.T) {
+	batch := testBatch()
+	data, err := proto.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := new(ChangeBatch)
+	if err := proto.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(batch, decoded) {
+		t.Errorf("round trip mismatch:\nwant %v\ngot  %v", batch.Events[0], decoded.Events[0])
+	}
+	// oneof中显式设置的零值需要保留，以区分空字符串与NULL
+	if _, ok := decoded.Events[0].After.Values[1].Kind.(*Value_StringValue); !ok {
+		t.Errorf("empty string value lost its kind: %v", decoded.Events[0].After.Values[1])
+	}
+}
+
+type testServer struct {
+	received []*ChangeBatch
+}
+
+func (s *testServer) Ping(_ context.Context, in *PingRequest) (*PongResponse, error) {
+	return &PongResponse{Context: "pong:" + in.Token}, nil
+}
+
+func (s *testServer) Stream(stream Transport_StreamServer) error {
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.received = append(s.received, batch)
+		if err := stream.Send(&BatchAck{BatchId: batch.BatchId, Success: batch.Token == "secret"}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestTransportService(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	srv := &testServer{}
+	RegisterTransportServer(server, srv)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewTransportClient(conn)
+
+	pong, err := client.Ping(context.Background(), &PingRequest{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pong.Context != "pong:secret" {
+		t.Errorf("unexpected pong: %s", pong.Context)
+	}
+
+	stream, err := client.Stream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		batch := testBatch()
+		batch.BatchId = uint64(i + 1)
+		if err := stream.Send(batch); err != nil {
+			t.Fatal(err)
+		}
+		ack, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ack.BatchId != batch.BatchId || !ack.Success {
+			t.Errorf("unexpected ack: %+v", ack)
+		}
+	}
+	stream.CloseSend()
+
+	if len(srv.received) != 3 || !proto.Equal(srv.received[2], &ChangeBatch{BatchId: 3, Token: "secret", Events: testBatch().Events}) {
+		t.Errorf("server received unexpected batches")
+	}
+}
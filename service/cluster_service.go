@@ -19,9 +19,21 @@ package service
 
 import (
 	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
 
 	"go-mysql-transfer/global"
 	"go-mysql-transfer/metrics"
+	"go-mysql-transfer/util/hashring"
+	"go-mysql-transfer/util/logs"
+)
+
+const (
+	_shardWatchInterval = 3   // 检查节点及规则变化的间隔(秒)
+	_shardReplicas      = 160 // 一致性哈希中每个节点的虚拟节点数
 )
 
 type ClusterService struct {
@@ -36,6 +48,9 @@ func (s *ClusterService) boot() error {
 	}
 
 	s.startElectListener()
+	if global.Cfg().IsSharding() {
+		s.startShardWatcher()
+	}
 
 	return nil
 }
@@ -49,9 +64,15 @@ func (s *ClusterService) startElectListener() {
 				global.SetLeaderFlag(selected)
 				if selected {
 					metrics.SetLeaderState(metrics.LeaderState)
-					_transferService.StartUp()
 				} else {
 					metrics.SetLeaderState(metrics.FollowerState)
+				}
+				if global.Cfg().IsSharding() { // 按表分片时所有节点都同步，由startShardWatcher分配
+					continue
+				}
+				if selected {
+					_transferService.StartUp()
+				} else {
 					_transferService.stopDump()
 				}
 			}
@@ -63,3 +84,51 @@ func (s *ClusterService) startElectListener() {
 func (s *ClusterService) Nodes() []string {
 	return _electionService.Nodes()
 }
+
+// startShardWatcher 按表分片时定时检查存活节点及规则，发生变化时重新分配本节点负责的表
+func (s *ClusterService) startShardWatcher() {
+	go func() {
+		var last string
+		for {
+			nodes := s.liveNodes()
+			keys := global.RuleKeyList()
+			sort.Strings(keys)
+			current := strings.Join(nodes, ",") + "|" + strings.Join(keys, ",")
+			if len(nodes) > 0 && current != last { // 读取节点失败时保持当前分配
+				tables := shardTables(keys, nodes, global.CurrentNode())
+				if err := _transferService.Reshard(tables); err != nil {
+					logs.Errorf("reshard: %s", errors.ErrorStack(err))
+				} else {
+					last = current
+				}
+			}
+			time.Sleep(_shardWatchInterval * time.Second)
+		}
+	}()
+}
+
+// liveNodes 排序去重后的存活节点
+func (s *ClusterService) liveNodes() []string {
+	seen := make(map[string]bool)
+	nodes := make([]string, 0)
+	for _, node := range _electionService.Nodes() {
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// shardTables 按一致性哈希分配给node的规则key，node不在存活节点中时不分配
+func shardTables(keys, nodes []string, node string) []string {
+	ring := hashring.New(_shardReplicas, nodes...)
+	tables := make([]string, 0)
+	for _, key := range keys {
+		if ring.Get(key) == node {
+			tables = append(tables, key)
+		}
+	}
+	return tables
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardTablesAddNode(t *testing.T) {
+	keys := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("shop:t_%d", i))
+	}
+
+	owners := func(nodes []string) map[string]string {
+		m := make(map[string]string)
+		for _, node := range nodes {
+			for _, key := range shardTables(keys, nodes, node) {
+				if owner, ok := m[key]; ok {
+					t.Fatalf("%s assigned to both %s and %s", key, owner, node)
+				}
+				m[key] = node
+			}
+		}
+		if len(m) != len(keys) {
+			t.Fatalf("want %d keys assigned, got %d", len(keys), len(m))
+		}
+		return m
+	}
+
+	before := owners([]string{"10.0.0.1:8060", "10.0.0.2:8060", "10.0.0.3:8060"})
+	after := owners([]string{"10.0.0.1:8060", "10.0.0.2:8060", "10.0.0.3:8060", "10.0.0.4:8060"})
+
+	// 新增节点只接管环上与其相邻的表，其他节点之间不发生迁移
+	var moved int
+	for _, key := range keys {
+		if before[key] == after[key] {
+			continue
+		}
+		if after[key] != "10.0.0.4:8060" {
+			t.Errorf("%s moved from %s to %s", key, before[key], after[key])
+		}
+		moved++
+	}
+	if moved == 0 || moved > len(keys)/2 {
+		t.Errorf("unexpected moved keys: %d", moved)
+	}
+}
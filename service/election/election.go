@@ -19,6 +19,7 @@ package election
 
 import (
 	"go-mysql-transfer/global"
+	"go-mysql-transfer/storage"
 )
 
 type Service interface {
@@ -29,6 +30,7 @@ type Service interface {
 }
 
 func NewElection(_informCh chan bool) Service {
+	storage.EnableFencing()
 	if global.Cfg().IsZk() {
 		return newZkElection(_informCh)
 	} else {
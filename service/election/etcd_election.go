@@ -81,6 +81,7 @@ func (s *etcdElection) doElect() {
 				s.beFollower("")
 				continue
 			default:
+				storage.SetFence(&storage.Fence{Key: elc.Key(), Token: elc.Rev()})
 				s.beLeader()
 				err = etcds.UpdateOrCreate(global.Cfg().ZkElectedDir(), elc.Key(), storage.EtcdOps())
 				if err != nil {
@@ -146,7 +147,7 @@ func (s *etcdElection) ensureFollower() {
 
 func (s *etcdElection) Nodes() []string {
 	var nodes []string
-	ls, err := etcds.List("/transfer/myTransfer/election", storage.EtcdOps())
+	ls, err := etcds.List(global.Cfg().ZkElectionDir(), storage.EtcdOps())
 	if err == nil {
 		for _, v := range ls {
 			nodes = append(nodes, string(v.Value))
@@ -163,6 +164,7 @@ func (s *etcdElection) beLeader() {
 }
 
 func (s *etcdElection) beFollower(leader string) {
+	storage.SetFence(nil)
 	s.selected.Store(false)
 	s.informCh <- s.selected.Load()
 	s.leader.Store(leader)
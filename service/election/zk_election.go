@@ -95,6 +95,7 @@ func (s *zkElection) Nodes() []string {
 }
 
 func (s *zkElection) beLeader() {
+	s.fence()
 	s.selected.Store(true)
 	s.leader.Store(global.CurrentNode())
 	s.informCh <- s.selected.Load()
@@ -102,12 +103,24 @@ func (s *zkElection) beLeader() {
 }
 
 func (s *zkElection) beFollower(leader string) {
+	storage.SetFence(nil)
 	s.selected.Store(false)
 	s.leader.Store(leader)
 	s.informCh <- s.selected.Load()
 	log.Println(fmt.Sprintf("The current node is the follower, master node is : %s", leader))
 }
 
+// fence 以选举节点的czxid作为fencing token，读取失败时不能保存位置
+func (s *zkElection) fence() {
+	exist, stat, err := storage.ZKConn().Exists(global.Cfg().ZkElectionDir())
+	if err != nil || !exist {
+		logs.Errorf("read election node for fencing token: %v", err)
+		storage.SetFence(nil)
+		return
+	}
+	storage.SetFence(&storage.Fence{Key: global.Cfg().ZkElectionDir(), Token: stat.Czxid})
+}
+
 func (s *zkElection) startConnectionWatchTask() {
 	logs.Info("Start zookeeper connection Status watch task")
 	go func() {
@@ -0,0 +1,405 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/schema"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/util/httpclient"
+	"go-mysql-transfer/util/logs"
+	"go-mysql-transfer/util/stringutil"
+)
+
+const (
+	_avroMagicByte = 0
+
+	_avroActionField = "_action"
+	_avroTsField     = "_timestamp"
+
+	_avroTypeLong   = "long"
+	_avroTypeDouble = "double"
+	_avroTypeString = "string"
+	_avroTypeBytes  = "bytes"
+)
+
+type avroField struct {
+	name     string
+	kind     string
+	padding  *model.Padding // 为空时表示默认列
+	defValue string
+}
+
+// avroSchema 一张表当前结构对应的schema及其在registry中的ID
+type avroSchema struct {
+	id     int
+	fields []*avroField
+}
+
+type avroSchemaKey struct {
+	subject string        // 包含topic及表，一个subject只对应一张表
+	table   *schema.Table // 表结构变化后canal会返回新的Table，以此触发重新注册
+}
+
+// schemaRegistry Confluent兼容的schema registry客户端
+type schemaRegistry struct {
+	addr     string
+	user     string
+	password string
+	client   *httpclient.HttpClient
+
+	lock    sync.Mutex
+	schemas map[avroSchemaKey]*avroSchema
+}
+
+func newSchemaRegistry(addr, user, password string) *schemaRegistry {
+	return &schemaRegistry{
+		addr:     strings.TrimSuffix(addr, "/"),
+		user:     user,
+		password: password,
+		client:   httpclient.NewClient().SetTimeout(10),
+		schemas:  make(map[avroSchemaKey]*avroSchema),
+	}
+}
+
+// newTargetSchemaRegistry 按当前配置创建接收端实例使用的schema registry客户端，
+// 配置了target_tls时使用相同的TLS配置；未配置schema_registry_addr时返回nil
+func newTargetSchemaRegistry() *schemaRegistry {
+	cfg := global.Cfg()
+	if cfg.SchemaRegistryAddr == "" {
+		return nil
+	}
+
+	registry := newSchemaRegistry(cfg.SchemaRegistryAddr, cfg.SchemaRegistryUser, cfg.SchemaRegistryPassword)
+	if transport := tlsTransport(); transport != nil {
+		registry.client.SetTransport(transport)
+	}
+	return registry
+}
+
+// message 使用Confluent wire format编码：magic byte + 4字节schema ID + Avro二进制数据
+func (s *schemaRegistry) message(topic string, row *model.RowRequest, rule *global.Rule) ([]byte, error) {
+	if s == nil {
+		return nil, errors.New("schema_registry_addr not configured")
+	}
+	return s.encode(avroSubject(topic, rule), row, rule)
+}
+
+// avroSubject 按TopicRecordNameStrategy命名subject(topic-库名.表名)，
+// 多张表写入同一个topic时各表的schema独立注册、独立演进
+func avroSubject(topic string, rule *global.Rule) string {
+	return topic + "-" + avroName(rule.Schema) + "." + avroName(rule.Table)
+}
+
+func (s *schemaRegistry) encode(subject string, row *model.RowRequest, rule *global.Rule) ([]byte, error) {
+	sc, err := s.schema(subject, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(_avroMagicByte)
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], uint32(sc.id))
+	buf.Write(id[:])
+
+	writeAvroString(&buf, row.Action)
+	writeAvroLong(&buf, int64(row.Timestamp))
+	for _, field := range sc.fields {
+		var value interface{}
+		if field.padding != nil {
+			value = convertColumnData(row.Row[field.padding.ColumnIndex], field.padding.ColumnMetadata, rule)
+		} else {
+			value = field.defValue
+		}
+		if err := writeAvroOptional(&buf, value, field.kind); err != nil {
+			return nil, errors.Annotatef(err, "field %s", field.name)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// schema 获取表结构对应的schema，首次使用或表结构变化时注册，registry会为新的schema生成新版本
+func (s *schemaRegistry) schema(subject string, rule *global.Rule) (*avroSchema, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := avroSchemaKey{subject: subject, table: rule.TableInfo}
+	if sc, ok := s.schemas[key]; ok {
+		return sc, nil
+	}
+
+	fields := avroFields(rule)
+	id, err := s.register(subject, avroSchemaJson(rule, fields))
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range s.schemas { // 移除该表旧结构的schema
+		if k.subject == subject {
+			delete(s.schemas, k)
+		}
+	}
+	sc := &avroSchema{id: id, fields: fields}
+	s.schemas[key] = sc
+	logs.Infof("avro schema registered, subject: %s, id: %d", subject, id)
+	return sc, nil
+}
+
+func (s *schemaRegistry) register(subject, schemaJson string) (int, error) {
+	post := s.client.POST(s.addr + "/subjects/" + url.PathEscape(subject) + "/versions")
+	if s.user != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(s.user + ":" + s.password))
+		post.AddHeader("Authorization", "Basic "+auth)
+	}
+
+	entity, err := post.SetBodyAsJson(map[string]string{"schema": schemaJson}).DoForEntity()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if entity.StatusCode() != 200 {
+		return 0, errors.Errorf("register schema %s failed: %d %s", subject, entity.StatusCode(), entity.DataAsString())
+	}
+
+	var ret struct {
+		Id int `json:"id"`
+	}
+	if err := entity.Unmarshal(&ret); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return ret.Id, nil
+}
+
+func avroFields(rule *global.Rule) []*avroField {
+	fields := make([]*avroField, 0, len(rule.PaddingMap)+len(rule.DefaultColumnValueMap))
+	for _, padding := range orderedPaddings(rule) {
+		fields = append(fields, &avroField{
+			name:    avroName(padding.WrapName),
+			kind:    avroKind(padding.ColumnMetadata),
+			padding: padding,
+		})
+	}
+	for _, column := range sortedDefaultColumns(rule) {
+		fields = append(fields, &avroField{
+			name:     avroName(rule.WrapName(column)),
+			kind:     _avroTypeString,
+			defValue: rule.DefaultColumnValueMap[column],
+		})
+	}
+	return fields
+}
+
+func avroSchemaJson(rule *global.Rule, fields []*avroField) string {
+	type field struct {
+		Name    string      `json:"name"`
+		Type    interface{} `json:"type"`
+		Default interface{} `json:"default"`
+	}
+	record := struct {
+		Type      string   `json:"type"`
+		Name      string   `json:"name"`
+		Namespace string   `json:"namespace"`
+		Fields    []*field `json:"fields"`
+	}{
+		Type:      "record",
+		Name:      avroName(rule.Table),
+		Namespace: avroName(rule.Schema),
+	}
+
+	record.Fields = append(record.Fields,
+		&field{Name: _avroActionField, Type: _avroTypeString, Default: ""},
+		&field{Name: _avroTsField, Type: _avroTypeLong, Default: 0},
+	)
+	for _, f := range fields {
+		record.Fields = append(record.Fields, &field{
+			Name:    f.name,
+			Type:    []string{"null", f.kind},
+			Default: nil,
+		})
+	}
+
+	data, _ := json.Marshal(record)
+	return string(data)
+}
+
+// avroKind 列类型对应的Avro类型，与convertColumnData的转换结果保持一致
+func avroKind(column *schema.TableColumn) string {
+	switch column.Type {
+	case schema.TYPE_NUMBER:
+		if unsignedBigint(column) { // 超出long范围，以字符串保存
+			return _avroTypeString
+		}
+		return _avroTypeLong
+	case schema.TYPE_MEDIUM_INT, schema.TYPE_BIT:
+		return _avroTypeLong
+	case schema.TYPE_FLOAT, schema.TYPE_DECIMAL:
+		return _avroTypeDouble
+	case schema.TYPE_BINARY, schema.TYPE_POINT:
+		return _avroTypeBytes
+	default:
+		return _avroTypeString
+	}
+}
+
+// unsignedBigint 无符号BIGINT的取值可能超出INT64范围
+func unsignedBigint(column *schema.TableColumn) bool {
+	return column.IsUnsigned && strings.HasPrefix(column.RawType, "bigint")
+}
+
+// avroName Avro名称只能包含字母、数字、下划线，且不能以数字开头
+func avroName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// writeAvroOptional 写入["null", kind]联合类型的值
+func writeAvroOptional(buf *bytes.Buffer, value interface{}, kind string) error {
+	if value == nil {
+		writeAvroLong(buf, 0)
+		return nil
+	}
+
+	switch kind {
+	case _avroTypeLong:
+		v, err := avroLong(value)
+		if err != nil {
+			return err
+		}
+		writeAvroLong(buf, 1)
+		writeAvroLong(buf, v)
+	case _avroTypeDouble:
+		v, err := avroDouble(value)
+		if err != nil {
+			return err
+		}
+		writeAvroLong(buf, 1)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		buf.Write(b[:])
+	case _avroTypeBytes:
+		writeAvroLong(buf, 1)
+		switch v := value.(type) {
+		case []byte:
+			writeAvroBytes(buf, v)
+		default:
+			writeAvroBytes(buf, []byte(stringutil.ToString(v)))
+		}
+	default:
+		writeAvroLong(buf, 1)
+		switch v := value.(type) {
+		case string:
+			writeAvroString(buf, v)
+		case []byte:
+			writeAvroBytes(buf, v)
+		case map[string]interface{}, []interface{}:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			writeAvroBytes(buf, data)
+		default:
+			writeAvroString(buf, stringutil.ToString(v))
+		}
+	}
+
+	return nil
+}
+
+func avroLong(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		if uint64(v) > math.MaxInt64 {
+			return 0, errors.Errorf("long value %d out of range", v)
+		}
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, errors.Errorf("long value %d out of range", v)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, errors.Errorf("unsupported long value %v", value)
+}
+
+func avroDouble(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	if v, err := avroLong(value); err == nil {
+		return float64(v), nil
+	}
+	return 0, errors.Errorf("unsupported double value %v", value)
+}
+
+func writeAvroLong(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v) // zigzag编码，与Avro一致
+	buf.Write(b[:n])
+}
+
+func writeAvroBytes(buf *bytes.Buffer, v []byte) {
+	writeAvroLong(buf, int64(len(v)))
+	buf.Write(v)
+}
+
+func writeAvroString(buf *bytes.Buffer, v string) {
+	writeAvroLong(buf, int64(len(v)))
+	buf.WriteString(v)
+}
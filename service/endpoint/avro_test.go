@@ -0,0 +1,242 @@
+package endpoint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/schema"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+)
+
+// registryStandIn 按subject记录注册过的schema，相同schema返回相同ID
+type registryStandIn struct {
+	lock     sync.Mutex
+	ids      map[string]int
+	subjects map[string]int
+	auth     string
+	requests int
+}
+
+func (s *registryStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.auth = r.Header.Get("Authorization")
+	s.requests++
+
+	data, _ := ioutil.ReadAll(r.Body)
+	var req struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.Schema == "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	id, ok := s.ids[req.Schema]
+	if !ok {
+		id = len(s.ids) + 1
+		s.ids[req.Schema] = id
+		s.subjects[r.URL.Path]++
+	}
+	w.Write([]byte(`{"id":` + strconv.Itoa(id) + `}`))
+}
+
+func readAvroLong(t *testing.T, r *bytes.Reader) int64 {
+	v, err := binary.ReadVarint(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func readAvroString(t *testing.T, r *bytes.Reader) string {
+	b := make([]byte, readAvroLong(t, r))
+	if _, err := r.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestAvroEncodeWithRegistry(t *testing.T) {
+	standIn := &registryStandIn{ids: make(map[string]int), subjects: make(map[string]int)}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	registry := newSchemaRegistry(server.URL, "user", "secret")
+	rule := testMessageRule()
+	req := &model.RowRequest{
+		Action:    canal.InsertAction,
+		Timestamp: 1602806400,
+		Row:       []interface{}{int64(7), "jerry", "9.50"},
+	}
+
+	body, err := registry.encode("user_topic-value", req, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if standIn.auth != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("unexpected authorization header: %s", standIn.auth)
+	}
+	if standIn.subjects["/subjects/user_topic-value/versions"] != 1 {
+		t.Errorf("schema not registered under subject: %v", standIn.subjects)
+	}
+
+	if body[0] != _avroMagicByte || binary.BigEndian.Uint32(body[1:5]) != 1 {
+		t.Fatalf("unexpected wire format header: %v", body[:5])
+	}
+	r := bytes.NewReader(body[5:])
+	if v := readAvroString(t, r); v != canal.InsertAction {
+		t.Errorf("unexpected action: %s", v)
+	}
+	if v := readAvroLong(t, r); v != 1602806400 {
+		t.Errorf("unexpected timestamp: %d", v)
+	}
+	if readAvroLong(t, r) != 1 || readAvroLong(t, r) != 7 {
+		t.Error("unexpected id")
+	}
+	if readAvroLong(t, r) != 1 || readAvroString(t, r) != "jerry" {
+		t.Error("unexpected name")
+	}
+	if readAvroLong(t, r) != 1 {
+		t.Fatal("score should not be null")
+	}
+	var b [8]byte
+	r.Read(b[:])
+	if math.Float64frombits(binary.LittleEndian.Uint64(b[:])) != 9.5 {
+		t.Error("unexpected score")
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d unexpected trailing bytes", r.Len())
+	}
+
+	// 相同表结构不重复注册
+	if _, err := registry.encode("user_topic-value", req, rule); err != nil {
+		t.Fatal(err)
+	}
+	if len(standIn.ids) != 1 {
+		t.Errorf("schema registered %d times", len(standIn.ids))
+	}
+
+	// 表结构变化后注册新版本
+	table := *rule.TableInfo
+	table.Columns = append(table.Columns, schema.TableColumn{Name: "remark", Type: schema.TYPE_STRING, RawType: "text"})
+	rule.TableInfo = &table
+	rule.PaddingMap["remark"] = &model.Padding{WrapName: "remark", ColumnName: "remark", ColumnIndex: 3, ColumnType: schema.TYPE_STRING, ColumnMetadata: &table.Columns[3]}
+	req.Row = append(req.Row, nil)
+
+	body, err = registry.encode("user_topic-value", req, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(body[1:5]) != 2 {
+		t.Errorf("expected schema id 2 after table changed, got %d", binary.BigEndian.Uint32(body[1:5]))
+	}
+	if body[len(body)-1] != 0 {
+		t.Error("null remark should be encoded as union index 0")
+	}
+}
+
+func TestAvroSubjectPerTable(t *testing.T) {
+	standIn := &registryStandIn{ids: make(map[string]int), subjects: make(map[string]int)}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	registry := newSchemaRegistry(server.URL, "", "")
+	user := testMessageRule()
+	order := testMessageRule()
+	order.Table = "t_order"
+	order.TableInfo = &schema.Table{Schema: "shop", Name: "t_order", Columns: user.TableInfo.Columns, PKColumns: []int{0}}
+
+	if subject := avroSubject("shop_topic", user); subject != "shop_topic-shop.t_user" {
+		t.Fatalf("unexpected subject: %s", subject)
+	}
+
+	// 两张表写入同一个topic，交替编码时不重复注册
+	req := &model.RowRequest{Action: canal.InsertAction, Row: []interface{}{int64(7), "jerry", "9.50"}}
+	for i := 0; i < 3; i++ {
+		for _, rule := range []*global.Rule{user, order} {
+			if _, err := registry.encode(avroSubject("shop_topic", rule), req, rule); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if standIn.requests != 2 {
+		t.Errorf("schemas registered %d times, want 2", standIn.requests)
+	}
+	if standIn.subjects["/subjects/shop_topic-shop.t_user/versions"] != 1 || standIn.subjects["/subjects/shop_topic-shop.t_order/versions"] != 1 {
+		t.Errorf("unexpected subjects: %v", standIn.subjects)
+	}
+}
+
+func TestAvroUnsignedBigint(t *testing.T) {
+	if _, err := avroLong(uint64(math.MaxUint64)); err == nil {
+		t.Error("expected out of range error")
+	}
+	if v, err := avroLong(uint64(math.MaxInt64)); err != nil || v != math.MaxInt64 {
+		t.Errorf("unexpected long %d %v", v, err)
+	}
+
+	column := &schema.TableColumn{Name: "id", Type: schema.TYPE_NUMBER, RawType: "bigint(20) unsigned", IsUnsigned: true}
+	if kind := avroKind(column); kind != _avroTypeString {
+		t.Errorf("unsigned bigint should be string, got %s", kind)
+	}
+	var buf bytes.Buffer
+	if err := writeAvroOptional(&buf, uint64(math.MaxUint64), avroKind(column)); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+	if readAvroLong(t, r) != 1 || readAvroString(t, r) != "18446744073709551615" {
+		t.Error("unexpected unsigned bigint value")
+	}
+
+	value := transportValue(uint64(math.MaxUint64))
+	if value.GetStringValue() != "18446744073709551615" {
+		t.Errorf("unexpected transport value: %v", value)
+	}
+	if value = transportValue(uint64(7)); value.GetIntValue() != 7 {
+		t.Errorf("unexpected transport value: %v", value)
+	}
+}
+
+func TestTargetSchemaRegistryTLS(t *testing.T) {
+	standIn := &registryStandIn{ids: make(map[string]int), subjects: make(map[string]int)}
+	server := httptest.NewTLSServer(standIn)
+	defer server.Close()
+	rule := testMessageRule()
+	req := &model.RowRequest{Action: canal.InsertAction, Row: []interface{}{int64(7), "jerry", "9.50"}}
+
+	// 未配置target_tls时无法校验测试服务端的自签名证书
+	global.ReplaceConfig(&global.Config{SchemaRegistryAddr: server.URL})
+	if _, err := newTargetSchemaRegistry().message("user_topic", req, rule); err == nil {
+		t.Fatal("expected certificate error without target_tls")
+	}
+
+	useTargetTLS(t, server, &global.Config{SchemaRegistryAddr: server.URL})
+	if _, err := newTargetSchemaRegistry().message("user_topic", req, rule); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个接收端实例按创建时的配置使用各自的registry
+	global.ReplaceConfig(&global.Config{})
+	if registry := newTargetSchemaRegistry(); registry != nil {
+		t.Fatal("registry created without schema_registry_addr")
+	}
+	if _, err := mqMessage("user_topic", req, &global.Rule{ValueEncoder: global.ValEncoderAvro}, nil); err == nil {
+		t.Error("expected error without schema registry")
+	}
+}
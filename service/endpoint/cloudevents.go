@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/canal"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/util/snowflake"
+	"go-mysql-transfer/util/stringutil"
+)
+
+const (
+	_cloudEventsSpecVersion = "1.0"
+	_cloudEventsTypePrefix  = "mysql.row."
+
+	_cloudEventsStructuredType = "application/cloudevents+json"
+	_cloudEventsDataType       = "application/json"
+)
+
+// cloudEvent CloudEvents 1.0 JSON格式的事件
+type cloudEvent struct {
+	SpecVersion     string             `json:"specversion"`
+	Id              string             `json:"id"`
+	Source          string             `json:"source"`
+	Type            string             `json:"type"`
+	Subject         string             `json:"subject,omitempty"`
+	Time            string             `json:"time"`
+	DataContentType string             `json:"datacontenttype"`
+	Data            *cloudEventRowData `json:"data"`
+}
+
+type cloudEventRowData struct {
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+// newCloudEvent source形如mysql://<server_name>/<schema>/<table>
+func newCloudEvent(source string, row *model.RowRequest, rule *global.Rule) (*cloudEvent, error) {
+	ts := time.Now()
+	if row.Timestamp > 0 {
+		ts = time.Unix(int64(row.Timestamp), 0)
+	}
+
+	event := &cloudEvent{
+		SpecVersion:     _cloudEventsSpecVersion,
+		Source:          source,
+		Subject:         stringutil.ToString(primaryKey(row, rule)),
+		Time:            ts.UTC().Format(time.RFC3339),
+		DataContentType: _cloudEventsDataType,
+		Data:            new(cloudEventRowData),
+	}
+
+	// 同一binlog位置可能包含多行，以行序号区分；全量同步时使用雪花ID
+	if row.LogName != "" {
+		event.Id = fmt.Sprintf("%s:%d:%d", row.LogName, row.LogPos, row.RowIndex)
+	} else {
+		id, err := snowflake.NextId()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		event.Id = stringutil.ToString(id)
+	}
+
+	switch row.Action {
+	case canal.InsertAction:
+		event.Type = _cloudEventsTypePrefix + "inserted"
+		event.Data.After = messageRow(row.Row, rule)
+	case canal.UpdateAction:
+		event.Type = _cloudEventsTypePrefix + "updated"
+		if row.Old != nil {
+			event.Data.Before = messageRow(row.Old, rule)
+		}
+		event.Data.After = messageRow(row.Row, rule)
+	case canal.DeleteAction:
+		event.Type = _cloudEventsTypePrefix + "deleted"
+		event.Data.Before = messageRow(row.Row, rule)
+	default:
+		event.Type = _cloudEventsTypePrefix + row.Action
+		event.Data.After = messageRow(row.Row, rule)
+	}
+
+	return event, nil
+}
+
+func cloudEventMessage(row *model.RowRequest, rule *global.Rule) (*mqPayload, error) {
+	source := fmt.Sprintf("mysql://%s/%s/%s", global.Cfg().ServerName, rule.Schema, rule.Table)
+	event, err := newCloudEvent(source, row, rule)
+	if err != nil {
+		return nil, err
+	}
+	return encodeCloudEvent(event, rule.CloudEventsMode)
+}
+
+// encodeCloudEvent structured模式下消息体为完整的事件；
+// binary模式下消息体只包含data，事件属性由接收端放入消息头
+func encodeCloudEvent(event *cloudEvent, mode string) (*mqPayload, error) {
+	if mode != global.CloudEventsBinary {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		return &mqPayload{body: body, contentType: _cloudEventsStructuredType}, nil
+	}
+
+	body, err := json.Marshal(event.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := []mqHeader{
+		{key: "specversion", value: event.SpecVersion},
+		{key: "id", value: event.Id},
+		{key: "source", value: event.Source},
+		{key: "type", value: event.Type},
+		{key: "time", value: event.Time},
+	}
+	if event.Subject != "" {
+		attributes = append(attributes, mqHeader{key: "subject", value: event.Subject})
+	}
+
+	return &mqPayload{
+		body:        body,
+		contentType: event.DataContentType,
+		attributes:  attributes,
+	}, nil
+}
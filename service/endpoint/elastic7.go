@@ -19,7 +19,9 @@ package endpoint
 
 import (
 	"context"
+	"io"
 	"log"
+	"net/http"
 	"sync"
 
 	"github.com/juju/errors"
@@ -56,6 +58,11 @@ func (s *Elastic7Endpoint) Connect() error {
 	var options []elastic.ClientOptionFunc
 	options = append(options, elastic.SetErrorLog(logagent.NewElsLoggerAgent()))
 	options = append(options, elastic.SetURL(s.hosts...))
+	if transport := tlsTransport(); transport != nil {
+		options = append(options, elastic.SetHttpClient(&http.Client{Transport: transport}))
+		// 嗅探到的节点地址不带协议，需同样使用https
+		options = append(options, elastic.SetScheme("https"))
+	}
 	if global.Cfg().ElsUser != "" && global.Cfg().ElsPassword != "" {
 		options = append(options, elastic.SetBasicAuth(global.Cfg().ElsUser, global.Cfg().Password))
 	}
@@ -69,6 +76,11 @@ func (s *Elastic7Endpoint) Connect() error {
 	return s.indexMapping()
 }
 
+// RefreshRules 规则热加载后，为新规则创建索引及映射
+func (s *Elastic7Endpoint) RefreshRules() error {
+	return s.indexMapping()
+}
+
 func (s *Elastic7Endpoint) indexMapping() error {
 	for _, rule := range global.RuleInsList() {
 		exists, err := s.client.IndexExists(rule.ElsIndex).Do(context.Background())
@@ -197,7 +209,10 @@ func (s *Elastic7Endpoint) Consume(from mysql.Position, rows []*model.RowRequest
 
 		if rule.LuaEnable() {
 			kvm := rowMap(row, rule, true)
+			span := luaSpan(row)
 			ls, err := luaengine.DoESOps(kvm, row.Action, rule)
+			span.SetError(err)
+			span.End()
 			if err != nil {
 				log.Println("Lua 脚本执行失败!!! ,详情请参见日志")
 				return errors.Errorf("lua 脚本执行失败 : %s ", errors.ErrorStack(err))
@@ -258,7 +273,10 @@ func (s *Elastic7Endpoint) Stock(rows []*model.RowRequest) int64 {
 
 		if rule.LuaEnable() {
 			kvm := rowMap(row, rule, true)
+			span := luaSpan(row)
 			ls, err := luaengine.DoESOps(kvm, row.Action, rule)
+			span.SetError(err)
+			span.End()
 			if err != nil {
 				logs.Errorf("lua 脚本执行失败 : %s ", errors.ErrorStack(err))
 				break
@@ -289,6 +307,59 @@ func (s *Elastic7Endpoint) Stock(rows []*model.RowRequest) int64 {
 	return int64(len(r.Succeeded()))
 }
 
+func (s *Elastic7Endpoint) Fetch(rule *global.Rule, rows []*model.RowRequest) ([]*VerifyRecord, error) {
+	records := make([]*VerifyRecord, 0, len(rows))
+	if len(rows) == 0 {
+		return records, nil
+	}
+
+	mget := s.client.Mget()
+	for _, row := range rows {
+		id := stringutil.ToString(primaryKey(row, rule))
+		records = append(records, &VerifyRecord{
+			Key:      id,
+			Expected: encodeValue(rule, rowMap(row, rule, false)),
+		})
+		mget.Add(elastic.NewMultiGetItem().Index(rule.ElsIndex).Id(id))
+	}
+	res, err := mget.Do(context.Background())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i, doc := range res.Docs {
+		if i < len(records) && doc.Found && doc.Source != nil {
+			records[i].Actual = string(doc.Source)
+		}
+	}
+	return records, nil
+}
+
+func (s *Elastic7Endpoint) Keys(rule *global.Rule, fn func(keys []string) error) error {
+	if sharedTarget(rule, func(other *global.Rule) bool { return other.ElsIndex == rule.ElsIndex }) {
+		return notSupportedf("index %s shared by multiple rules", rule.ElsIndex)
+	}
+
+	ctx := context.Background()
+	scroll := s.client.Scroll(rule.ElsIndex).FetchSource(false).Size(int(global.Cfg().BulkSize))
+	defer scroll.Clear(ctx)
+	for {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		keys := make([]string, 0, len(res.Hits.Hits))
+		for _, hit := range res.Hits.Hits {
+			keys = append(keys, hit.Id)
+		}
+		if err := fn(keys); err != nil {
+			return err
+		}
+	}
+}
+
 func (s *Elastic7Endpoint) prepareBulk(action, index, id, doc string, bulk *elastic.BulkService) {
 	switch action {
 	case canal.InsertAction:
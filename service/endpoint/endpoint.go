@@ -19,6 +19,7 @@ package endpoint
 
 import (
 	"bytes"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -31,6 +32,7 @@ import (
 	"go-mysql-transfer/global"
 	"go-mysql-transfer/model"
 	"go-mysql-transfer/service/luaengine"
+	"go-mysql-transfer/tracing"
 	"go-mysql-transfer/util/logs"
 	"go-mysql-transfer/util/stringutil"
 )
@@ -47,9 +49,25 @@ type Endpoint interface {
 	Close()
 }
 
+// Committer 可选接口，由先缓存数据、再批量提交的接收端(如Parquet)实现。
+// 保存binlog位置前调用Commit，只有返回的已提交位置才会被保存；
+// ok为false表示尚无数据提交，此时不保存位置
+type Committer interface {
+	Commit(pos mysql.Position) (committed mysql.Position, ok bool, err error)
+}
+
+// RuleRefresher 可选接口，由缓存了规则相关资源(如Mongo集合、RabbitMQ队列、ES索引映射)的接收端实现，
+// 规则热加载后调用
+type RuleRefresher interface {
+	RefreshRules() error
+}
+
+// NewEndpoint 创建接收端并以ds初始化Lua运行环境，ds为nil时沿用当前的Lua运行环境
 func NewEndpoint(ds *canal.Canal) Endpoint {
 	cfg := global.Cfg()
-	luaengine.InitActuator(ds)
+	if ds != nil {
+		luaengine.InitActuator(ds)
+	}
 
 	if cfg.IsRedis() {
 		return newRedisEndpoint()
@@ -84,6 +102,26 @@ func NewEndpoint(ds *canal.Canal) Endpoint {
 		return newScriptEndpoint()
 	}
 
+	if cfg.IsFile() {
+		return newFileEndpoint()
+	}
+
+	if cfg.IsParquet() {
+		return newParquetEndpoint()
+	}
+
+	if cfg.IsS3() {
+		return newS3Endpoint()
+	}
+
+	if cfg.IsGrpc() {
+		return newGrpcEndpoint()
+	}
+
+	if cfg.IsHttp() {
+		return newHttpEndpoint()
+	}
+
 	return nil
 }
 
@@ -281,6 +319,17 @@ func rowMap(req *model.RowRequest, rule *global.Rule, primitive bool) map[string
 	return kv
 }
 
+// luaSpan 执行Lua脚本的span，作为读取binlog事件span的子span；全量同步的数据不追踪
+func luaSpan(req *model.RowRequest) *tracing.Span {
+	if !req.Trace.IsValid() {
+		return nil
+	}
+	span := tracing.Start("lua.execute", tracing.KindInternal, req.Trace)
+	span.SetAttribute("db.table", req.RuleKey)
+	span.SetAttribute("action", req.Action)
+	return span
+}
+
 func oldRowMap(req *model.RowRequest, rule *global.Rule, primitive bool) map[string]interface{} {
 	kv := make(map[string]interface{}, len(rule.PaddingMap))
 
@@ -322,11 +371,16 @@ func primaryKey(re *model.RowRequest, rule *global.Rule) interface{} {
 }
 
 func elsHosts(addr string) []string {
+	scheme := "http://"
+	if global.Cfg().TargetTLSConfig() != nil {
+		scheme = "https://"
+	}
+
 	var hosts []string
 	splits := strings.Split(addr, ",")
 	for _, split := range splits {
-		if !strings.HasPrefix(split, "http:") {
-			hosts = append(hosts, "http://"+split)
+		if !strings.HasPrefix(split, "http:") && !strings.HasPrefix(split, "https:") {
+			hosts = append(hosts, scheme+split)
 		} else {
 			hosts = append(hosts, split)
 		}
@@ -335,6 +389,18 @@ func elsHosts(addr string) []string {
 	return hosts
 }
 
+// tlsTransport 配置了target_tls时返回使用该配置的http.Transport，否则返回nil
+func tlsTransport() *http.Transport {
+	tlsConfig := global.Cfg().TargetTLSConfig()
+	if tlsConfig == nil {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
+
 func buildPropertiesByRule(rule *global.Rule) map[string]interface{} {
 	properties := make(map[string]interface{})
 	for _, padding := range rule.PaddingMap {
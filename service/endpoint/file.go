@@ -0,0 +1,441 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/metrics"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/util/files"
+	"go-mysql-transfer/util/logs"
+	"go-mysql-transfer/util/stringutil"
+)
+
+const (
+	_fileCombinedName = "transfer"
+	_fileRollLayout   = "20060102150405"
+	_fileBufferSize   = 64 * 1024
+)
+
+type fileRecord struct {
+	Schema    string      `json:"schema"`
+	Table     string      `json:"table"`
+	Action    string      `json:"action"`
+	Timestamp uint32      `json:"timestamp"`
+	Raw       interface{} `json:"raw,omitempty"`
+	Data      interface{} `json:"data"`
+}
+
+type FileEndpoint struct {
+	lock  sync.Mutex
+	files map[string]*rollingFile
+}
+
+func newFileEndpoint() *FileEndpoint {
+	r := &FileEndpoint{}
+	r.files = make(map[string]*rollingFile)
+	return r
+}
+
+func (s *FileEndpoint) Connect() error {
+	return files.MkdirIfNecessary(global.Cfg().FileDir)
+}
+
+func (s *FileEndpoint) Ping() error {
+	if !files.IsDir(global.Cfg().FileDir) {
+		return errors.Errorf("file_dir %s not available", global.Cfg().FileDir)
+	}
+	return nil
+}
+
+func (s *FileEndpoint) Consume(from mysql.Position, rows []*model.RowRequest) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	touched := make(map[*rollingFile]bool)
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		metrics.UpdateActionNum(row.Action, row.RuleKey)
+
+		f, err := s.write(row, rule)
+		if err != nil {
+			return err
+		}
+		touched[f] = true
+	}
+
+	// 刷盘后才返回，确保保存的binlog位置不会超前于已落盘的数据
+	for f := range touched {
+		if err := f.sync(); err != nil {
+			return err
+		}
+	}
+
+	logs.Infof("处理完成 %d 条数据", len(rows))
+	return nil
+}
+
+func (s *FileEndpoint) Stock(rows []*model.RowRequest) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var sum int64
+	touched := make(map[*rollingFile]bool)
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		f, err := s.write(row, rule)
+		if err != nil {
+			logs.Error(errors.ErrorStack(err))
+			break
+		}
+		touched[f] = true
+		sum++
+	}
+
+	for f := range touched {
+		if err := f.sync(); err != nil {
+			logs.Error(errors.ErrorStack(err))
+			return 0
+		}
+	}
+
+	return sum
+}
+
+func (s *FileEndpoint) write(row *model.RowRequest, rule *global.Rule) (*rollingFile, error) {
+	f, err := s.file(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	if global.Cfg().FileFormat == global.FileFormatCsv {
+		err = f.writeRecord(s.csvRecord(row, rule))
+	} else {
+		var line []byte
+		line, err = jsonLine(row, rule)
+		if err == nil {
+			err = f.writeLine(line)
+		}
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return f, nil
+}
+
+func (s *FileEndpoint) file(rule *global.Rule) (*rollingFile, error) {
+	name := _fileCombinedName
+	if global.Cfg().FilePerTable {
+		name = rule.Schema + "." + rule.Table
+	}
+
+	f, ok := s.files[name]
+	if ok {
+		return f, nil
+	}
+
+	cfg := global.Cfg()
+	f = newRollingFile(cfg.FileDir, name, cfg.FileFormat,
+		int64(cfg.FileMaxSize)*1024*1024,
+		time.Duration(cfg.FileRollInterval)*time.Minute,
+		cfg.FileGzip)
+	if cfg.FileFormat == global.FileFormatCsv && cfg.FilePerTable {
+		f.header = s.csvHeader(rule)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	s.files[name] = f
+	return f, nil
+}
+
+func jsonLine(row *model.RowRequest, rule *global.Rule) ([]byte, error) {
+	record := &fileRecord{
+		Schema:    rule.Schema,
+		Table:     rule.Table,
+		Action:    row.Action,
+		Timestamp: row.Timestamp,
+		Data:      rowMap(row, rule, false),
+	}
+	if rule.ReserveRawData && canal.UpdateAction == row.Action {
+		record.Raw = oldRowMap(row, rule, false)
+	}
+
+	return json.Marshal(record)
+}
+
+func (s *FileEndpoint) csvHeader(rule *global.Rule) []string {
+	header := []string{"schema", "table", "action", "timestamp"}
+	for _, padding := range orderedPaddings(rule) {
+		header = append(header, padding.WrapName)
+	}
+	for _, k := range sortedDefaultColumns(rule) {
+		header = append(header, rule.WrapName(k))
+	}
+	return header
+}
+
+func (s *FileEndpoint) csvRecord(row *model.RowRequest, rule *global.Rule) []string {
+	record := []string{rule.Schema, rule.Table, row.Action, strconv.FormatUint(uint64(row.Timestamp), 10)}
+	for _, padding := range orderedPaddings(rule) {
+		value := convertColumnData(row.Row[padding.ColumnIndex], padding.ColumnMetadata, rule)
+		record = append(record, stringutil.ToString(value))
+	}
+	for _, k := range sortedDefaultColumns(rule) {
+		record = append(record, rule.DefaultColumnValueMap[k])
+	}
+	return record
+}
+
+func (s *FileEndpoint) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, f := range s.files {
+		if err := f.close(); err != nil {
+			logs.Error(err.Error())
+		}
+	}
+}
+
+// 按列的顺序排列
+func orderedPaddings(rule *global.Rule) []*model.Padding {
+	ls := make([]*model.Padding, 0, len(rule.PaddingMap))
+	for _, padding := range rule.PaddingMap {
+		ls = append(ls, padding)
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		return ls[i].ColumnIndex < ls[j].ColumnIndex
+	})
+	return ls
+}
+
+func sortedDefaultColumns(rule *global.Rule) []string {
+	ls := make([]string, 0, len(rule.DefaultColumnValueMap))
+	for k := range rule.DefaultColumnValueMap {
+		ls = append(ls, k)
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+// rollingFile 按大小、时间滚动的文件
+type rollingFile struct {
+	dir      string
+	name     string
+	ext      string
+	maxSize  int64
+	interval time.Duration
+	compress bool
+	header   []string
+
+	file     *os.File
+	buffer   *bufio.Writer
+	csv      *csv.Writer
+	size     int64
+	openTime time.Time
+}
+
+func newRollingFile(dir, name, ext string, maxSize int64, interval time.Duration, compress bool) *rollingFile {
+	return &rollingFile{
+		dir:      dir,
+		name:     name,
+		ext:      ext,
+		maxSize:  maxSize,
+		interval: interval,
+		compress: compress,
+	}
+}
+
+func (s *rollingFile) path() string {
+	return filepath.Join(s.dir, s.name+"."+s.ext)
+}
+
+func (s *rollingFile) open() error {
+	file, err := os.OpenFile(s.path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Trace(err)
+	}
+
+	s.file = file
+	s.buffer = bufio.NewWriterSize(file, _fileBufferSize)
+	s.csv = csv.NewWriter(s)
+	s.size = stat.Size()
+	s.openTime = time.Now()
+
+	if s.size == 0 && len(s.header) > 0 {
+		return s.csv.Write(s.header)
+	}
+	return nil
+}
+
+// Write 实现io.Writer，统计写入的字节数
+func (s *rollingFile) Write(p []byte) (int, error) {
+	n, err := s.buffer.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *rollingFile) writeLine(line []byte) error {
+	if err := s.rollIfNecessary(); err != nil {
+		return err
+	}
+	if _, err := s.Write(line); err != nil {
+		return err
+	}
+	_, err := s.Write([]byte{'\n'})
+	return err
+}
+
+func (s *rollingFile) writeRecord(record []string) error {
+	if err := s.rollIfNecessary(); err != nil {
+		return err
+	}
+	return s.csv.Write(record)
+}
+
+func (s *rollingFile) rollIfNecessary() error {
+	if s.file == nil { // 上次滚动后未能重新打开
+		return s.open()
+	}
+	if s.csv != nil {
+		s.csv.Flush()
+	}
+	if s.maxSize > 0 && s.size >= s.maxSize {
+		return s.roll()
+	}
+	if s.interval > 0 && s.size > 0 && time.Since(s.openTime) >= s.interval {
+		return s.roll()
+	}
+	return nil
+}
+
+func (s *rollingFile) roll() error {
+	if err := s.close(); err != nil {
+		return err
+	}
+
+	target := filepath.Join(s.dir, fmt.Sprintf("%s-%s.%s", s.name, time.Now().Format(_fileRollLayout), s.ext))
+	for i := 1; files.IsExist(target) || files.IsExist(target+".gz"); i++ {
+		target = filepath.Join(s.dir, fmt.Sprintf("%s-%s-%d.%s", s.name, time.Now().Format(_fileRollLayout), i, s.ext))
+	}
+	if err := os.Rename(s.path(), target); err != nil {
+		if openErr := s.open(); openErr != nil { // 继续写入当前文件
+			logs.Error(errors.ErrorStack(openErr))
+		}
+		return errors.Trace(err)
+	}
+	logs.Infof("file rolled: %s", target)
+
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	// 压缩失败时保留未压缩的文件，不影响后续写入
+	if s.compress {
+		if err := gzipFile(target); err != nil {
+			logs.Errorf("gzip file %s: %s", target, errors.ErrorStack(err))
+		}
+	}
+	return nil
+}
+
+// sync 将缓冲区的数据写入磁盘
+func (s *rollingFile) sync() error {
+	s.csv.Flush()
+	if err := s.csv.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := s.buffer.Flush(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.file.Sync())
+}
+
+func (s *rollingFile) close() error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.sync(); err != nil {
+		return err
+	}
+	err := s.file.Close()
+	s.file = nil
+	return errors.Trace(err)
+}
+
+func gzipFile(source string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(source+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	gw := gzip.NewWriter(out)
+	if _, err = io.Copy(gw, in); err == nil {
+		err = gw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err != nil {
+		os.Remove(source + ".gz")
+		return errors.Trace(err)
+	}
+
+	return os.Remove(source)
+}
@@ -0,0 +1,99 @@
+package endpoint
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRollingFileRollBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newRollingFile(dir, "eseap.t_user", "csv", 16, 0, true)
+	f.header = []string{"schema", "table", "action"}
+	if err := f.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := f.writeRecord([]string{"eseap", "t_user", "insert"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rolled, _ := filepath.Glob(filepath.Join(dir, "eseap.t_user-*.csv.gz"))
+	if len(rolled) == 0 {
+		t.Fatal("expected rolled gzip files")
+	}
+
+	gf, err := os.Open(rolled[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gf.Close()
+	gr, err := gzip.NewReader(gf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "schema,table,action\n") {
+		t.Errorf("rolled file should start with csv header, got %q", string(data))
+	}
+
+	active, err := ioutil.ReadFile(filepath.Join(dir, "eseap.t_user.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(active), "schema,table,action\n") {
+		t.Errorf("active file should start with csv header, got %q", string(active))
+	}
+}
+
+func TestRollingFileReopenAfterFailedRoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newRollingFile(dir, "eseap.t_user", "csv", 0, 0, false)
+	f.header = []string{"schema", "table", "action"}
+	if err := f.open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.writeRecord([]string{"eseap", "t_user", "insert"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 滚动时关闭了当前文件但未能重新打开
+	if err := f.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.writeRecord([]string{"eseap", "t_user", "update"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(f.path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "schema,table,action\neseap,t_user,insert\neseap,t_user,update\n" {
+		t.Errorf("unexpected content: %q", data)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/metrics"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/proto/transport"
+	"go-mysql-transfer/util/logs"
+	"go-mysql-transfer/util/stringutil"
+)
+
+const _grpcPingTimeout = 5 * time.Second
+
+type GrpcEndpoint struct {
+	lock    sync.Mutex
+	conn    *grpc.ClientConn
+	client  transport.TransportClient
+	stream  transport.Transport_StreamClient
+	cancel  context.CancelFunc
+	batchId uint64
+}
+
+func newGrpcEndpoint() *GrpcEndpoint {
+	r := &GrpcEndpoint{}
+	return r
+}
+
+func (s *GrpcEndpoint) Connect() error {
+	security := grpc.WithInsecure()
+	if tlsConfig := global.Cfg().TargetTLSConfig(); tlsConfig != nil {
+		security = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+	}
+	conn, err := grpc.Dial(global.Cfg().GrpcAddr, security)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.conn = conn
+	s.client = transport.NewTransportClient(conn)
+	return s.Ping()
+}
+
+func (s *GrpcEndpoint) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), _grpcPingTimeout)
+	defer cancel()
+
+	_, err := s.client.Ping(ctx, &transport.PingRequest{
+		Token: global.Cfg().GrpcToken,
+	})
+	return errors.Trace(err)
+}
+
+func (s *GrpcEndpoint) Consume(from mysql.Position, rows []*model.RowRequest) error {
+	events := make([]*transport.ChangeEvent, 0, len(rows))
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		metrics.UpdateActionNum(row.Action, row.RuleKey)
+		events = append(events, changeEvent(row, rule))
+	}
+
+	// 收到服务端的成功应答后才返回，保证保存的binlog位置不会超前于已送达的数据
+	if err := s.send(events); err != nil {
+		return err
+	}
+
+	logs.Infof("处理完成 %d 条数据", len(rows))
+	return nil
+}
+
+func (s *GrpcEndpoint) Stock(rows []*model.RowRequest) int64 {
+	events := make([]*transport.ChangeEvent, 0, len(rows))
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+		events = append(events, changeEvent(row, rule))
+	}
+
+	if err := s.send(events); err != nil {
+		logs.Error(errors.ErrorStack(err))
+		return 0
+	}
+
+	return int64(len(events))
+}
+
+// send 发送一批数据并等待应答
+func (s *GrpcEndpoint) send(events []*transport.ChangeEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stream == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := s.client.Stream(ctx)
+		if err != nil {
+			cancel()
+			return errors.Trace(err)
+		}
+		s.stream = stream
+		s.cancel = cancel
+	}
+
+	s.batchId++
+	batch := &transport.ChangeBatch{
+		BatchId: s.batchId,
+		Events:  events,
+		Token:   global.Cfg().GrpcToken,
+	}
+	if err := s.stream.Send(batch); err != nil {
+		s.resetStream()
+		return errors.Trace(err)
+	}
+
+	ack, err := s.recvAck(time.Duration(global.Cfg().GrpcAckTimeout) * time.Second)
+	if err != nil {
+		s.resetStream()
+		return err
+	}
+	if ack.BatchId != batch.BatchId {
+		s.resetStream()
+		return errors.Errorf("unexpected ack batch id %d, want %d", ack.BatchId, batch.BatchId)
+	}
+	if !ack.Success {
+		return errors.Errorf("batch %d rejected: %s", ack.BatchId, ack.Message)
+	}
+
+	return nil
+}
+
+func (s *GrpcEndpoint) recvAck(timeout time.Duration) (*transport.BatchAck, error) {
+	type result struct {
+		ack *transport.BatchAck
+		err error
+	}
+
+	stream := s.stream
+	ch := make(chan result, 1)
+	go func() {
+		ack, err := stream.Recv()
+		ch <- result{ack: ack, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		return r.ack, errors.Trace(r.err)
+	case <-timer.C:
+		return nil, errors.Errorf("wait for ack timeout after %s", timeout)
+	}
+}
+
+// resetStream 关闭当前流，下次发送时重新建立
+func (s *GrpcEndpoint) resetStream() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.stream = nil
+	s.cancel = nil
+}
+
+func (s *GrpcEndpoint) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stream != nil {
+		s.stream.CloseSend()
+		s.resetStream()
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// changeEvent 构建proto/transport.proto中定义的ChangeEvent
+func changeEvent(row *model.RowRequest, rule *global.Rule) *transport.ChangeEvent {
+	event := &transport.ChangeEvent{
+		Schema:    rule.Schema,
+		Table:     rule.Table,
+		Action:    row.Action,
+		Timestamp: row.Timestamp,
+		Position: &transport.Position{
+			Name:     row.LogName,
+			Pos:      row.LogPos,
+			ServerId: row.ServerId,
+		},
+	}
+
+	paddings := orderedPaddings(rule)
+	defaults := sortedDefaultColumns(rule)
+	for _, padding := range paddings {
+		event.Columns = append(event.Columns, &transport.Column{
+			Name:      padding.WrapName,
+			MysqlType: padding.ColumnMetadata.RawType,
+			Type:      int32(padding.ColumnType),
+			Primary:   isPKColumn(rule, padding.ColumnIndex),
+		})
+	}
+	for _, column := range defaults {
+		event.Columns = append(event.Columns, &transport.Column{
+			Name: rule.WrapName(column),
+			Type: schema.TYPE_STRING,
+		})
+	}
+
+	image := func(values []interface{}) *transport.RowImage {
+		ri := &transport.RowImage{Values: make([]*transport.Value, 0, len(event.Columns))}
+		for _, padding := range paddings {
+			v := convertColumnData(values[padding.ColumnIndex], padding.ColumnMetadata, rule)
+			ri.Values = append(ri.Values, transportValue(v))
+		}
+		for _, column := range defaults {
+			ri.Values = append(ri.Values, transportValue(rule.DefaultColumnValueMap[column]))
+		}
+		return ri
+	}
+
+	switch row.Action {
+	case canal.UpdateAction:
+		if row.Old != nil {
+			event.Before = image(row.Old)
+		}
+		event.After = image(row.Row)
+	case canal.DeleteAction:
+		event.Before = image(row.Row)
+	default:
+		event.After = image(row.Row)
+	}
+
+	return event
+}
+
+func transportValue(value interface{}) *transport.Value {
+	switch v := value.(type) {
+	case nil:
+		return &transport.Value{Kind: &transport.Value_NullValue{NullValue: true}}
+	case string:
+		return &transport.Value{Kind: &transport.Value_StringValue{StringValue: v}}
+	case []byte:
+		return &transport.Value{Kind: &transport.Value_BytesValue{BytesValue: v}}
+	case float32:
+		return &transport.Value{Kind: &transport.Value_DoubleValue{DoubleValue: float64(v)}}
+	case float64:
+		return &transport.Value{Kind: &transport.Value_DoubleValue{DoubleValue: v}}
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return &transport.Value{Kind: &transport.Value_NullValue{NullValue: true}}
+		}
+		return &transport.Value{Kind: &transport.Value_StringValue{StringValue: string(data)}}
+	}
+
+	// 超出int64范围的无符号BIGINT以字符串传输
+	if i, err := avroLong(value); err == nil {
+		return &transport.Value{Kind: &transport.Value_IntValue{IntValue: i}}
+	}
+	return &transport.Value{Kind: &transport.Value_StringValue{StringValue: stringutil.ToString(value)}}
+}
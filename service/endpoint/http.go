@@ -0,0 +1,166 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/mysql"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/metrics"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/tracing"
+	"go-mysql-transfer/util/logs"
+)
+
+const _httpDefaultContentType = "application/json"
+
+type HttpEndpoint struct {
+	client   *http.Client
+	registry *schemaRegistry
+}
+
+func newHttpEndpoint() *HttpEndpoint {
+	r := &HttpEndpoint{}
+	r.registry = newTargetSchemaRegistry()
+	return r
+}
+
+func (s *HttpEndpoint) Connect() error {
+	s.client = &http.Client{
+		Timeout: time.Duration(global.Cfg().HttpTimeout) * time.Second,
+	}
+	if transport := tlsTransport(); transport != nil {
+		s.client.Transport = transport
+	}
+	return s.Ping()
+}
+
+// Ping 只检查接收端地址是否可达，不发送请求
+func (s *HttpEndpoint) Ping() error {
+	u, err := url.Parse(global.Cfg().HttpUrl)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", host, s.client.Timeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return conn.Close()
+}
+
+func (s *HttpEndpoint) Consume(from mysql.Position, rows []*model.RowRequest) error {
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		metrics.UpdateActionNum(row.Action, row.RuleKey)
+
+		if err := s.send(row, rule); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	logs.Infof("处理完成 %d 条数据", len(rows))
+	return nil
+}
+
+func (s *HttpEndpoint) Stock(rows []*model.RowRequest) int64 {
+	var sum int64
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		if err := s.send(row, rule); err != nil {
+			logs.Error(errors.ErrorStack(err))
+			break
+		}
+		sum++
+	}
+
+	return sum
+}
+
+// send 每条数据一个POST请求，非2xx响应视为失败
+func (s *HttpEndpoint) send(row *model.RowRequest, rule *global.Rule) error {
+	payload, err := mqMessage(row.RuleKey, row, rule, s.registry)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, global.Cfg().HttpUrl, bytes.NewReader(payload.body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	contentType := _httpDefaultContentType
+	if payload.contentType != "" {
+		contentType = payload.contentType
+	}
+	request.Header.Set("Content-Type", contentType)
+	// CloudEvents HTTP协议绑定：属性使用ce-前缀的请求头
+	for _, attr := range payload.attributes {
+		request.Header.Set("ce-"+attr.key, attr.value)
+	}
+	if row.Trace.IsValid() {
+		request.Header.Set(tracing.TraceParentHeader, row.Trace.TraceParent())
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.Errorf("http target respond status %d", response.StatusCode)
+	}
+
+	logs.Infof("url: %s, message: %s", global.Cfg().HttpUrl, string(payload.body))
+	return nil
+}
+
+func (s *HttpEndpoint) Close() {
+	if s.client != nil {
+		s.client.CloseIdleConnections()
+	}
+}
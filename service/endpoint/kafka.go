@@ -18,45 +18,46 @@
 package endpoint
 
 import (
-	"github.com/siddontang/go-mysql/canal"
+	"crypto/sha256"
+	"crypto/sha512"
 	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/juju/errors"
 	"github.com/siddontang/go-mysql/mysql"
+	"github.com/xdg/scram"
 
 	"go-mysql-transfer/global"
 	"go-mysql-transfer/metrics"
 	"go-mysql-transfer/model"
 	"go-mysql-transfer/service/luaengine"
+	"go-mysql-transfer/tracing"
 	"go-mysql-transfer/util/logs"
 )
 
 type KafkaEndpoint struct {
 	client   sarama.Client
 	producer sarama.AsyncProducer
+	registry *schemaRegistry
 
 	retryLock sync.Mutex
 }
 
 func newKafkaEndpoint() *KafkaEndpoint {
 	r := &KafkaEndpoint{}
+	r.registry = newTargetSchemaRegistry()
 	return r
 }
 
 func (s *KafkaEndpoint) Connect() error {
-	cfg := sarama.NewConfig()
-	cfg.Producer.Partitioner = sarama.NewRandomPartitioner
-
-	if global.Cfg().KafkaSASLUser != "" && global.Cfg().KafkaSASLPassword != "" {
-		cfg.Net.SASL.Enable = true
-		cfg.Net.SASL.User = global.Cfg().KafkaSASLUser
-		cfg.Net.SASL.Password = global.Cfg().KafkaSASLPassword
+	cfg, err := kafkaConfig()
+	if err != nil {
+		return err
 	}
 
-	var err error
 	var client sarama.Client
 	ls := strings.Split(global.Cfg().KafkaAddr, ",")
 	client, err = sarama.NewClient(ls, cfg)
@@ -76,6 +77,86 @@ func (s *KafkaEndpoint) Connect() error {
 	return nil
 }
 
+// kafkaConfig 根据配置构建sarama.Config，配置项已在checkKafkaConfig中校验
+func kafkaConfig() (*sarama.Config, error) {
+	c := global.Cfg()
+	cfg := sarama.NewConfig()
+	cfg.Producer.Partitioner = sarama.NewRandomPartitioner
+
+	if c.KafkaVersion != "" {
+		version, err := sarama.ParseKafkaVersion(c.KafkaVersion)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		cfg.Version = version
+	}
+	// 消息头需要Kafka 0.11及以上版本
+	if !cfg.Version.IsAtLeast(sarama.V0_11_0_0) {
+		for _, rule := range global.RuleInsList() {
+			if rule.MessageHeaders || rule.LuaEnable() || tracing.Enabled() ||
+				(rule.MessageFormat == global.MessageFormatCloud && rule.CloudEventsMode == global.CloudEventsBinary) {
+				if c.KafkaVersion != "" {
+					logs.Warnf("kafka_version %s does not support message headers, headers will be dropped", c.KafkaVersion)
+				} else {
+					cfg.Version = sarama.V0_11_0_0
+				}
+				break
+			}
+		}
+	}
+
+	if c.KafkaSASLUser != "" && c.KafkaSASLPassword != "" {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.User = c.KafkaSASLUser
+		cfg.Net.SASL.Password = c.KafkaSASLPassword
+		cfg.Net.SASL.Mechanism = sarama.SASLMechanism(c.KafkaSASLMechanism)
+		switch c.KafkaSASLMechanism {
+		case sarama.SASLTypeSCRAMSHA256:
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha256.New}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha512.New}
+			}
+		}
+	}
+
+	if tlsConfig := c.TargetTLSConfig(); tlsConfig != nil {
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+
+	switch c.KafkaCompression {
+	case "gzip":
+		cfg.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		cfg.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		cfg.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		cfg.Producer.Compression = sarama.CompressionZSTD
+	}
+
+	if c.KafkaLingerMs > 0 {
+		cfg.Producer.Flush.Frequency = time.Duration(c.KafkaLingerMs) * time.Millisecond
+	}
+	if c.KafkaBatchSize > 0 {
+		cfg.Producer.Flush.Bytes = c.KafkaBatchSize
+	}
+	if c.KafkaBatchMessages > 0 {
+		cfg.Producer.Flush.Messages = c.KafkaBatchMessages
+	}
+	if c.KafkaMaxMessageBytes > 0 {
+		cfg.Producer.MaxMessageBytes = c.KafkaMaxMessageBytes
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cfg, nil
+}
+
 func (s *KafkaEndpoint) Ping() error {
 	return s.client.RefreshMetadata()
 }
@@ -177,7 +258,10 @@ func (s *KafkaEndpoint) Stock(rows []*model.RowRequest) int64 {
 
 func (s *KafkaEndpoint) buildMessages(row *model.RowRequest, rule *global.Rule) ([]*sarama.ProducerMessage, error) {
 	kvm := rowMap(row, rule, true)
+	span := luaSpan(row)
 	ls, err := luaengine.DoMQOps(kvm, row.Action, rule)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		return nil, errors.Errorf("lua 脚本执行失败 : %s ", err)
 	}
@@ -185,8 +269,9 @@ func (s *KafkaEndpoint) buildMessages(row *model.RowRequest, rule *global.Rule)
 	var ms []*sarama.ProducerMessage
 	for _, resp := range ls {
 		m := &sarama.ProducerMessage{
-			Topic: resp.Topic,
-			Value: sarama.ByteEncoder(resp.ByteArray),
+			Topic:   resp.Topic,
+			Value:   sarama.ByteEncoder(resp.ByteArray),
+			Headers: kafkaHeaders(messageHeaders(row, rule, resp.Headers)),
 		}
 		logs.Infof("topic: %s, message: %s", resp.Topic, string(resp.ByteArray))
 		ms = append(ms, m)
@@ -196,32 +281,47 @@ func (s *KafkaEndpoint) buildMessages(row *model.RowRequest, rule *global.Rule)
 }
 
 func (s *KafkaEndpoint) buildMessage(row *model.RowRequest, rule *global.Rule) (*sarama.ProducerMessage, error) {
-	kvm := rowMap(row, rule, false)
-	resp := new(model.MQRespond)
-	resp.Action = row.Action
-	resp.Timestamp = row.Timestamp
-	if rule.ValueEncoder == global.ValEncoderJson {
-		resp.Date = kvm
-	} else {
-		resp.Date = encodeValue(rule, kvm)
-	}
-
-	if rule.ReserveRawData && canal.UpdateAction == row.Action {
-		resp.Raw = oldRowMap(row, rule, false)
-	}
-
-	body, err := json.Marshal(resp)
+	payload, err := mqMessage(rule.KafkaTopic, row, rule, s.registry)
 	if err != nil {
 		return nil, err
 	}
 	m := &sarama.ProducerMessage{
-		Topic: rule.KafkaTopic,
-		Value: sarama.ByteEncoder(body),
+		Topic:   rule.KafkaTopic,
+		Value:   sarama.ByteEncoder(payload.body),
+		Headers: kafkaHeaders(payload.headers),
 	}
-	logs.Infof("topic: %s, message: %s", rule.KafkaTopic, string(body))
+	// CloudEvents Kafka协议绑定：属性使用ce_前缀的消息头
+	if len(payload.attributes) > 0 {
+		for _, attr := range payload.attributes {
+			m.Headers = append(m.Headers, sarama.RecordHeader{
+				Key:   []byte("ce_" + attr.key),
+				Value: []byte(attr.value),
+			})
+		}
+		m.Headers = append(m.Headers, sarama.RecordHeader{
+			Key:   []byte("content-type"),
+			Value: []byte(payload.contentType),
+		})
+	}
+	logs.Infof("topic: %s, message: %s", rule.KafkaTopic, string(payload.body))
 	return m, nil
 }
 
+func kafkaHeaders(headers []mqHeader) []sarama.RecordHeader {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	ls := make([]sarama.RecordHeader, 0, len(headers))
+	for _, header := range headers {
+		ls = append(ls, sarama.RecordHeader{
+			Key:   []byte(header.key),
+			Value: []byte(header.value),
+		})
+	}
+	return ls
+}
+
 func (s *KafkaEndpoint) Close() {
 	if s.producer != nil {
 		s.producer.Close()
@@ -230,3 +330,28 @@ func (s *KafkaEndpoint) Close() {
 		s.client.Close()
 	}
 }
+
+// scramClient 基于xdg/scram实现sarama.SCRAMClient
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (s *scramClient) Begin(userName, password, authzID string) error {
+	client, err := s.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	s.Client = client
+	s.ClientConversation = client.NewConversation()
+	return nil
+}
+
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.ClientConversation.Step(challenge)
+}
+
+func (s *scramClient) Done() bool {
+	return s.ClientConversation.Done()
+}
@@ -0,0 +1,587 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/schema"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/tracing"
+	"go-mysql-transfer/util/stringutil"
+)
+
+const (
+	_debeziumConnector = "mysql"
+	_debeziumVersion   = "go-mysql-transfer"
+
+	_debeziumOpCreate = "c"
+	_debeziumOpUpdate = "u"
+	_debeziumOpDelete = "d"
+	_debeziumOpRead   = "r"
+)
+
+// debeziumSource Debezium消息中的source块
+type debeziumSource struct {
+	Version   string  `json:"version"`
+	Connector string  `json:"connector"`
+	Name      string  `json:"name"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	Db        string  `json:"db"`
+	Table     string  `json:"table"`
+	ServerId  uint32  `json:"server_id"`
+	Gtid      *string `json:"gtid"`
+	File      string  `json:"file"`
+	Pos       uint32  `json:"pos"`
+	Row       int     `json:"row"`
+	Thread    *int64  `json:"thread"`
+	Query     *string `json:"query"`
+}
+
+type debeziumPayload struct {
+	Before interface{}     `json:"before"`
+	After  interface{}     `json:"after"`
+	Source *debeziumSource `json:"source"`
+	Op     string          `json:"op"`
+	TsMs   int64           `json:"ts_ms"`
+}
+
+type debeziumEnvelope struct {
+	Schema  *connectSchema   `json:"schema"`
+	Payload *debeziumPayload `json:"payload"`
+}
+
+// canalFlatMessage 与Alibaba Canal的FlatMessage(canal-json)一致
+type canalFlatMessage struct {
+	Id        int64                    `json:"id"`
+	Database  string                   `json:"database"`
+	Table     string                   `json:"table"`
+	PkNames   []string                 `json:"pkNames"`
+	IsDdl     bool                     `json:"isDdl"`
+	Type      string                   `json:"type"`
+	Es        int64                    `json:"es"`
+	Ts        int64                    `json:"ts"`
+	Sql       string                   `json:"sql"`
+	SqlType   map[string]int           `json:"sqlType"`
+	MysqlType map[string]string        `json:"mysqlType"`
+	Data      []map[string]interface{} `json:"data"`
+	Old       []map[string]interface{} `json:"old"`
+}
+
+// maxwellRecord 与Maxwell的输出(maxwell-json)一致
+type maxwellRecord struct {
+	Database          string                 `json:"database"`
+	Table             string                 `json:"table"`
+	Type              string                 `json:"type"`
+	Ts                int64                  `json:"ts"`
+	Position          string                 `json:"position,omitempty"`
+	ServerId          uint32                 `json:"server_id,omitempty"`
+	PrimaryKeyColumns []string               `json:"primary_key_columns"`
+	Data              map[string]interface{} `json:"data"`
+	Old               map[string]interface{} `json:"old,omitempty"`
+}
+
+// connectSchema Kafka Connect JsonConverter格式的schema描述
+type connectSchema struct {
+	Type     string           `json:"type"`
+	Fields   []*connectSchema `json:"fields,omitempty"`
+	Optional bool             `json:"optional"`
+	Name     string           `json:"name,omitempty"`
+	Field    string           `json:"field,omitempty"`
+}
+
+// mqPayload 消息体及其附加信息
+type mqPayload struct {
+	body        []byte
+	contentType string     // 为空时使用接收端默认的类型
+	headers     []mqHeader // 来源信息及自定义消息头
+	attributes  []mqHeader // CloudEvents binary模式下的事件属性，由接收端按各自协议的约定放入消息头
+}
+
+type mqHeader struct {
+	key   string
+	value string
+}
+
+// mqMessage 根据规则的message_format、value_encoder构建发往topic的消息，registry为接收端实例的schema registry客户端
+func mqMessage(topic string, row *model.RowRequest, rule *global.Rule, registry *schemaRegistry) (*mqPayload, error) {
+	var body []byte
+	var err error
+	payload := new(mqPayload)
+
+	switch rule.ValueEncoder {
+	case global.ValEncoderAvro:
+		payload.contentType = "avro/binary"
+		body, err = registry.message(topic, row, rule)
+	case global.ValEncoderProtobuf:
+		payload.contentType = "application/x-protobuf"
+		body, err = proto.Marshal(changeEvent(row, rule))
+	default:
+		switch rule.MessageFormat {
+		case global.MessageFormatDebezium:
+			body, err = debeziumMessage(row, rule)
+		case global.MessageFormatCanal:
+			body, err = canalMessage(row, rule)
+		case global.MessageFormatMaxwell:
+			body, err = maxwellMessage(row, rule)
+		case global.MessageFormatCloud:
+			payload, err = cloudEventMessage(row, rule)
+			if err != nil {
+				return nil, err
+			}
+			body = payload.body
+		default:
+			body, err = defaultMessage(row, rule)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload.body = body
+	payload.headers = messageHeaders(row, rule, nil)
+	return payload, nil
+}
+
+// messageHeaders 规则开启message_headers时携带来源信息，消费端无需解析消息体即可路由；
+// custom为Lua脚本中设置的消息头，同名时覆盖来源信息
+func messageHeaders(row *model.RowRequest, rule *global.Rule, custom map[string]string) []mqHeader {
+	var headers []mqHeader
+	if rule.MessageHeaders {
+		headers = append(headers,
+			mqHeader{key: "schema", value: rule.Schema},
+			mqHeader{key: "table", value: rule.Table},
+			mqHeader{key: "action", value: row.Action},
+			mqHeader{key: "timestamp", value: strconv.FormatUint(uint64(row.Timestamp), 10)},
+			mqHeader{key: "seq", value: strconv.Itoa(row.RowIndex)},
+		)
+		if row.LogName != "" {
+			headers = append(headers,
+				mqHeader{key: "binlog_file", value: row.LogName},
+				mqHeader{key: "binlog_pos", value: strconv.FormatUint(uint64(row.LogPos), 10)},
+			)
+		}
+		if row.Gtid != "" {
+			headers = append(headers, mqHeader{key: "gtid", value: row.Gtid})
+		}
+	}
+	// 开启链路追踪时以W3C traceparent传播读取binlog事件的span
+	if row.Trace.IsValid() {
+		headers = append(headers, mqHeader{key: tracing.TraceParentHeader, value: row.Trace.TraceParent()})
+	}
+
+	if len(custom) == 0 {
+		return headers
+	}
+
+	keys := make([]string, 0, len(custom))
+	for k := range custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		replaced := false
+		for i := range headers {
+			if headers[i].key == k {
+				headers[i].value = custom[k]
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			headers = append(headers, mqHeader{key: k, value: custom[k]})
+		}
+	}
+	return headers
+}
+
+func defaultMessage(row *model.RowRequest, rule *global.Rule) ([]byte, error) {
+	kvm := rowMap(row, rule, false)
+	resp := new(model.MQRespond)
+	resp.Action = row.Action
+	resp.Timestamp = row.Timestamp
+	if rule.ValueEncoder == global.ValEncoderJson {
+		resp.Date = kvm
+	} else {
+		resp.Date = encodeValue(rule, kvm)
+	}
+
+	if rule.ReserveRawData && canal.UpdateAction == row.Action {
+		resp.Raw = oldRowMap(row, rule, false)
+	}
+
+	return json.Marshal(resp)
+}
+
+func debeziumMessage(row *model.RowRequest, rule *global.Rule) ([]byte, error) {
+	payload := &debeziumPayload{
+		Source: &debeziumSource{
+			Version:   _debeziumVersion,
+			Connector: _debeziumConnector,
+			Name:      global.Cfg().ServerName,
+			TsMs:      int64(row.Timestamp) * 1000,
+			Snapshot:  "false",
+			Db:        rule.Schema,
+			Table:     rule.Table,
+			ServerId:  row.ServerId,
+			File:      row.LogName,
+			Pos:       row.LogPos,
+			Row:       row.RowIndex,
+		},
+		TsMs: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if row.Gtid != "" {
+		gtid := row.Gtid
+		payload.Source.Gtid = &gtid
+	}
+
+	switch row.Action {
+	case canal.InsertAction:
+		payload.Op = _debeziumOpCreate
+		payload.After = messageRow(row.Row, rule)
+	case canal.UpdateAction:
+		payload.Op = _debeziumOpUpdate
+		if row.Old != nil {
+			payload.Before = messageRow(row.Old, rule)
+		}
+		payload.After = messageRow(row.Row, rule)
+	case canal.DeleteAction:
+		payload.Op = _debeziumOpDelete
+		payload.Before = messageRow(row.Row, rule)
+	}
+
+	// 全量同步的数据没有binlog位置，按快照读处理
+	if row.LogName == "" {
+		payload.Op = _debeziumOpRead
+		payload.Source.Snapshot = "true"
+		if payload.Source.TsMs == 0 {
+			payload.Source.TsMs = payload.TsMs
+		}
+	}
+
+	if !rule.MessageSchemaEnable {
+		return json.Marshal(payload)
+	}
+
+	return json.Marshal(&debeziumEnvelope{
+		Schema:  debeziumSchema(rule),
+		Payload: payload,
+	})
+}
+
+func canalMessage(row *model.RowRequest, rule *global.Rule) ([]byte, error) {
+	msg := &canalFlatMessage{
+		Database:  rule.Schema,
+		Table:     rule.Table,
+		PkNames:   pkNames(rule),
+		Type:      strings.ToUpper(row.Action),
+		Es:        int64(row.Timestamp) * 1000,
+		Ts:        time.Now().UnixNano() / int64(time.Millisecond),
+		SqlType:   make(map[string]int, len(rule.PaddingMap)),
+		MysqlType: make(map[string]string, len(rule.PaddingMap)),
+	}
+	if msg.Es == 0 {
+		msg.Es = msg.Ts
+	}
+
+	for _, padding := range rule.PaddingMap {
+		msg.MysqlType[padding.WrapName] = padding.ColumnMetadata.RawType
+		msg.SqlType[padding.WrapName] = jdbcType(padding.ColumnMetadata)
+	}
+	for k := range rule.DefaultColumnValueMap {
+		msg.MysqlType[rule.WrapName(k)] = "varchar"
+		msg.SqlType[rule.WrapName(k)] = _jdbcVarchar
+	}
+
+	// canal-json中的值均为字符串
+	data := make(map[string]interface{}, len(rule.PaddingMap)+len(rule.DefaultColumnValueMap))
+	for k, v := range messageRow(row.Row, rule) {
+		data[k] = canalValue(v)
+	}
+	msg.Data = []map[string]interface{}{data}
+
+	if row.Action == canal.UpdateAction && row.Old != nil {
+		old := make(map[string]interface{})
+		for k, v := range messageRow(row.Old, rule) {
+			if value := canalValue(v); !equalValue(value, data[k]) {
+				old[k] = value
+			}
+		}
+		msg.Old = []map[string]interface{}{old}
+	}
+
+	return json.Marshal(msg)
+}
+
+func maxwellMessage(row *model.RowRequest, rule *global.Rule) ([]byte, error) {
+	msg := &maxwellRecord{
+		Database:          rule.Schema,
+		Table:             rule.Table,
+		Type:              row.Action,
+		Ts:                int64(row.Timestamp),
+		ServerId:          row.ServerId,
+		PrimaryKeyColumns: pkNames(rule),
+		Data:              rowMap(row, rule, false),
+	}
+
+	if row.LogName != "" {
+		msg.Position = fmt.Sprintf("%s:%d", row.LogName, row.LogPos)
+	} else {
+		// 全量同步的数据与maxwell的bootstrap保持一致
+		msg.Type = "bootstrap-" + row.Action
+	}
+	if msg.Ts == 0 {
+		msg.Ts = time.Now().Unix()
+	}
+
+	if row.Action == canal.UpdateAction && row.Old != nil {
+		msg.Old = make(map[string]interface{})
+		for k, v := range oldRowMap(row, rule, false) {
+			if !equalValue(v, msg.Data[k]) {
+				msg.Old[k] = v
+			}
+		}
+	}
+
+	return json.Marshal(msg)
+}
+
+func pkNames(rule *global.Rule) []string {
+	names := make([]string, 0, len(rule.TableInfo.PKColumns))
+	for _, index := range rule.TableInfo.PKColumns {
+		names = append(names, rule.WrapName(rule.TableInfo.Columns[index].Name))
+	}
+	return names
+}
+
+func canalValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if v, ok := value.([]byte); ok {
+		return string(v)
+	}
+	return stringutil.ToString(value)
+}
+
+// equalValue 比较两个列值，用于找出update中发生变化的列
+func equalValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// messageRow 行数据，JSON列输出为字符串(与Debezium、Canal一致)
+func messageRow(values []interface{}, rule *global.Rule) map[string]interface{} {
+	kv := make(map[string]interface{}, len(rule.PaddingMap)+len(rule.DefaultColumnValueMap))
+	for k, v := range rule.DefaultColumnValueMap {
+		kv[rule.WrapName(k)] = v
+	}
+
+	for _, padding := range rule.PaddingMap {
+		value := convertColumnData(values[padding.ColumnIndex], padding.ColumnMetadata, rule)
+		if padding.ColumnType == schema.TYPE_JSON && value != nil {
+			if data, err := json.Marshal(value); err == nil {
+				value = string(data)
+			}
+		}
+		kv[padding.WrapName] = value
+	}
+
+	return kv
+}
+
+func debeziumSchema(rule *global.Rule) *connectSchema {
+	prefix := global.Cfg().ServerName + "." + rule.Schema + "." + rule.Table
+
+	value := &connectSchema{
+		Type:     "struct",
+		Optional: true,
+		Name:     prefix + ".Value",
+	}
+	for _, padding := range orderedPaddings(rule) {
+		value.Fields = append(value.Fields, &connectSchema{
+			Type:     connectType(padding.ColumnMetadata),
+			Optional: !isPKColumn(rule, padding.ColumnIndex),
+			Name:     connectLogicalName(padding.ColumnMetadata),
+			Field:    padding.WrapName,
+		})
+	}
+	for _, column := range sortedDefaultColumns(rule) {
+		value.Fields = append(value.Fields, &connectSchema{
+			Type:     "string",
+			Optional: true,
+			Field:    rule.WrapName(column),
+		})
+	}
+
+	before := *value
+	before.Field = "before"
+	after := *value
+	after.Field = "after"
+
+	return &connectSchema{
+		Type:     "struct",
+		Optional: false,
+		Name:     prefix + ".Envelope",
+		Fields: []*connectSchema{
+			&before,
+			&after,
+			debeziumSourceSchema(),
+			{Type: "string", Optional: false, Field: "op"},
+			{Type: "int64", Optional: true, Field: "ts_ms"},
+		},
+	}
+}
+
+func debeziumSourceSchema() *connectSchema {
+	return &connectSchema{
+		Type:     "struct",
+		Optional: false,
+		Name:     "io.debezium.connector.mysql.Source",
+		Field:    "source",
+		Fields: []*connectSchema{
+			{Type: "string", Optional: false, Field: "version"},
+			{Type: "string", Optional: false, Field: "connector"},
+			{Type: "string", Optional: false, Field: "name"},
+			{Type: "int64", Optional: false, Field: "ts_ms"},
+			{Type: "string", Optional: true, Field: "snapshot"},
+			{Type: "string", Optional: false, Field: "db"},
+			{Type: "string", Optional: true, Field: "table"},
+			{Type: "int64", Optional: false, Field: "server_id"},
+			{Type: "string", Optional: true, Field: "gtid"},
+			{Type: "string", Optional: false, Field: "file"},
+			{Type: "int64", Optional: false, Field: "pos"},
+			{Type: "int32", Optional: false, Field: "row"},
+			{Type: "int64", Optional: true, Field: "thread"},
+			{Type: "string", Optional: true, Field: "query"},
+		},
+	}
+}
+
+// connectType 列类型对应的schema类型，与convertColumnData的转换结果保持一致
+func connectType(column *schema.TableColumn) string {
+	switch column.Type {
+	case schema.TYPE_NUMBER, schema.TYPE_MEDIUM_INT, schema.TYPE_BIT:
+		return "int64"
+	case schema.TYPE_FLOAT, schema.TYPE_DECIMAL:
+		return "double"
+	case schema.TYPE_BINARY, schema.TYPE_POINT:
+		return "bytes"
+	default:
+		return "string"
+	}
+}
+
+func connectLogicalName(column *schema.TableColumn) string {
+	switch column.Type {
+	case schema.TYPE_JSON:
+		return "io.debezium.data.Json"
+	case schema.TYPE_ENUM:
+		return "io.debezium.data.Enum"
+	case schema.TYPE_SET:
+		return "io.debezium.data.EnumSet"
+	default:
+		return ""
+	}
+}
+
+// java.sql.Types
+const (
+	_jdbcBit       = -7
+	_jdbcTinyInt   = -6
+	_jdbcSmallInt  = 5
+	_jdbcInteger   = 4
+	_jdbcBigInt    = -5
+	_jdbcReal      = 7
+	_jdbcDouble    = 8
+	_jdbcDecimal   = 3
+	_jdbcChar      = 1
+	_jdbcVarchar   = 12
+	_jdbcBinary    = -2
+	_jdbcVarbinary = -3
+	_jdbcDate      = 91
+	_jdbcTime      = 92
+	_jdbcTimestamp = 93
+	_jdbcBlob      = 2004
+	_jdbcClob      = 2005
+)
+
+// jdbcType 与Canal一致，根据列的原始类型推导java.sql.Types
+func jdbcType(column *schema.TableColumn) int {
+	raw := strings.ToLower(column.RawType)
+	if i := strings.IndexAny(raw, "( "); i > 0 {
+		raw = raw[:i]
+	}
+
+	switch raw {
+	case "bit":
+		return _jdbcBit
+	case "tinyint":
+		return _jdbcTinyInt
+	case "smallint":
+		return _jdbcSmallInt
+	case "mediumint", "int", "integer":
+		return _jdbcInteger
+	case "bigint":
+		return _jdbcBigInt
+	case "float":
+		return _jdbcReal
+	case "double", "real":
+		return _jdbcDouble
+	case "decimal", "numeric":
+		return _jdbcDecimal
+	case "char":
+		return _jdbcChar
+	case "binary":
+		return _jdbcBinary
+	case "varbinary":
+		return _jdbcVarbinary
+	case "date":
+		return _jdbcDate
+	case "time":
+		return _jdbcTime
+	case "datetime", "timestamp":
+		return _jdbcTimestamp
+	case "tinyblob", "blob", "mediumblob", "longblob":
+		return _jdbcBlob
+	case "tinytext", "text", "mediumtext", "longtext":
+		return _jdbcClob
+	default:
+		return _jdbcVarchar
+	}
+}
+
+func isPKColumn(rule *global.Rule, index int) bool {
+	for _, pk := range rule.TableInfo.PKColumns {
+		if pk == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+package endpoint
+
+import (
+	"testing"
+
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/schema"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+)
+
+func testMessageRule() *global.Rule {
+	table := &schema.Table{
+		Schema: "shop",
+		Name:   "t_user",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER, RawType: "bigint(20)"},
+			{Name: "name", Type: schema.TYPE_STRING, RawType: "varchar(64)"},
+			{Name: "score", Type: schema.TYPE_DECIMAL, RawType: "decimal(10,2)"},
+		},
+		PKColumns: []int{0},
+	}
+
+	rule := &global.Rule{
+		Schema:    "shop",
+		Table:     "t_user",
+		TableInfo: table,
+		PaddingMap: map[string]*model.Padding{
+			"id":    {WrapName: "id", ColumnName: "id", ColumnIndex: 0, ColumnType: schema.TYPE_NUMBER, ColumnMetadata: &table.Columns[0]},
+			"name":  {WrapName: "name", ColumnName: "name", ColumnIndex: 1, ColumnType: schema.TYPE_STRING, ColumnMetadata: &table.Columns[1]},
+			"score": {WrapName: "score", ColumnName: "score", ColumnIndex: 2, ColumnType: schema.TYPE_DECIMAL, ColumnMetadata: &table.Columns[2]},
+		},
+	}
+	return rule
+}
+
+func testUpdateRequest() *model.RowRequest {
+	return &model.RowRequest{
+		RuleKey:   "shop:t_user",
+		Action:    canal.UpdateAction,
+		Timestamp: 1602806400,
+		LogName:   "mysql-bin.000003",
+		LogPos:    1234,
+		ServerId:  1,
+		Old:       []interface{}{int64(7), "tom", "9.50"},
+		Row:       []interface{}{int64(7), "jerry", "9.50"},
+	}
+}
+
+func TestCanalMessage(t *testing.T) {
+	body, err := canalMessage(testUpdateRequest(), testMessageRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg canalFlatMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Type != "UPDATE" || msg.Database != "shop" || msg.Table != "t_user" {
+		t.Errorf("unexpected header: %s", body)
+	}
+	if msg.Es != 1602806400000 {
+		t.Errorf("expected es in milliseconds, got %d", msg.Es)
+	}
+	if len(msg.PkNames) != 1 || msg.PkNames[0] != "id" {
+		t.Errorf("unexpected pkNames: %v", msg.PkNames)
+	}
+	if msg.MysqlType["score"] != "decimal(10,2)" || msg.SqlType["score"] != _jdbcDecimal || msg.SqlType["id"] != _jdbcBigInt {
+		t.Errorf("unexpected types: %v %v", msg.MysqlType, msg.SqlType)
+	}
+	if len(msg.Data) != 1 || msg.Data[0]["id"] != "7" || msg.Data[0]["name"] != "jerry" {
+		t.Errorf("unexpected data: %v", msg.Data)
+	}
+	if len(msg.Old) != 1 || len(msg.Old[0]) != 1 || msg.Old[0]["name"] != "tom" {
+		t.Errorf("old should only contain changed columns: %v", msg.Old)
+	}
+}
+
+func TestDebeziumMessage(t *testing.T) {
+	global.ReplaceConfig(&global.Config{ServerName: "db1"})
+	rule := testMessageRule()
+
+	cases := []struct {
+		action string
+		op     string
+		before string // before中name的值，为空表示before为null
+		after  string
+	}{
+		{canal.InsertAction, "c", "", "jerry"},
+		{canal.UpdateAction, "u", "tom", "jerry"},
+		{canal.DeleteAction, "d", "jerry", ""},
+	}
+	for _, c := range cases {
+		row := testUpdateRequest()
+		row.Action = c.action
+		row.Gtid = "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
+		body, err := debeziumMessage(row, rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msg struct {
+			Before map[string]interface{} `json:"before"`
+			After  map[string]interface{} `json:"after"`
+			Source debeziumSource         `json:"source"`
+			Op     string                 `json:"op"`
+			TsMs   int64                  `json:"ts_ms"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Op != c.op {
+			t.Errorf("%s: op %s, want %s", c.action, msg.Op, c.op)
+		}
+		if (c.before == "" && msg.Before != nil) || (c.before != "" && msg.Before["name"] != c.before) {
+			t.Errorf("%s: unexpected before %v", c.action, msg.Before)
+		}
+		if (c.after == "" && msg.After != nil) || (c.after != "" && msg.After["name"] != c.after) {
+			t.Errorf("%s: unexpected after %v", c.action, msg.After)
+		}
+		if msg.Source.TsMs != 1602806400000 || msg.TsMs <= 0 {
+			t.Errorf("%s: unexpected ts_ms %d %d", c.action, msg.Source.TsMs, msg.TsMs)
+		}
+		if msg.Source.Gtid == nil || *msg.Source.Gtid != row.Gtid {
+			t.Errorf("%s: unexpected gtid %v", c.action, msg.Source.Gtid)
+		}
+		if msg.Source.Name != "db1" || msg.Source.File != "mysql-bin.000003" || msg.Source.Pos != 1234 || msg.Source.Snapshot != "false" {
+			t.Errorf("%s: unexpected source %+v", c.action, msg.Source)
+		}
+	}
+
+	// 全量同步的数据按快照读处理，没有gtid
+	stock := testUpdateRequest()
+	stock.Action = canal.InsertAction
+	stock.LogName = ""
+	body, err := debeziumMessage(stock, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg debeziumPayload
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Op != "r" || msg.Source.Snapshot != "true" || msg.Source.Gtid != nil {
+		t.Errorf("unexpected stock message: %s", body)
+	}
+}
+
+func TestMaxwellMessage(t *testing.T) {
+	body, err := maxwellMessage(testUpdateRequest(), testMessageRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg maxwellRecord
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Type != canal.UpdateAction || msg.Ts != 1602806400 {
+		t.Errorf("unexpected header: %s", body)
+	}
+	if msg.Position != "mysql-bin.000003:1234" {
+		t.Errorf("unexpected position: %s", msg.Position)
+	}
+	if msg.Data["id"] != float64(7) || msg.Data["score"] != 9.5 {
+		t.Errorf("unexpected data: %v", msg.Data)
+	}
+	if len(msg.Old) != 1 || msg.Old["name"] != "tom" {
+		t.Errorf("old should only contain changed columns: %v", msg.Old)
+	}
+
+	stock := testUpdateRequest()
+	stock.Action = canal.InsertAction
+	stock.LogName = ""
+	body, err = maxwellMessage(stock, testMessageRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg = maxwellRecord{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "bootstrap-insert" || msg.Position != "" {
+		t.Errorf("unexpected stock message: %s", body)
+	}
+}
+
+func TestCloudEventMessage(t *testing.T) {
+	event, err := newCloudEvent("mysql://db1/shop/t_user", testUpdateRequest(), testMessageRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Id != "mysql-bin.000003:1234:0" || event.Type != "mysql.row.updated" || event.Subject != "7" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event.Time != "2020-10-16T00:00:00Z" {
+		t.Errorf("unexpected time: %s", event.Time)
+	}
+
+	structured, err := encodeCloudEvent(event, global.CloudEventsStructured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded cloudEvent
+	if err := json.Unmarshal(structured.body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if structured.contentType != "application/cloudevents+json" || len(structured.attributes) != 0 {
+		t.Errorf("unexpected structured payload: %+v", structured)
+	}
+	if decoded.Source != "mysql://db1/shop/t_user" || decoded.Data.Before["name"] != "tom" || decoded.Data.After["name"] != "jerry" {
+		t.Errorf("unexpected structured body: %s", structured.body)
+	}
+
+	binary, err := encodeCloudEvent(event, global.CloudEventsBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.contentType != "application/json" {
+		t.Errorf("unexpected content type: %s", binary.contentType)
+	}
+	attributes := make(map[string]string)
+	for _, attr := range binary.attributes {
+		attributes[attr.key] = attr.value
+	}
+	if attributes["specversion"] != "1.0" || attributes["id"] != event.Id || attributes["subject"] != "7" {
+		t.Errorf("unexpected attributes: %v", attributes)
+	}
+	var data cloudEventRowData
+	if err := json.Unmarshal(binary.body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.After["name"] != "jerry" {
+		t.Errorf("unexpected binary body: %s", binary.body)
+	}
+}
+
+func TestMessageHeaders(t *testing.T) {
+	rule := testMessageRule()
+	row := testUpdateRequest()
+	row.Gtid = "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
+
+	if headers := messageHeaders(row, rule, nil); len(headers) != 0 {
+		t.Errorf("headers should be empty when message_headers disabled: %v", headers)
+	}
+
+	rule.MessageHeaders = true
+	headers := messageHeaders(row, rule, map[string]string{"action": "modify", "tenant": "t1"})
+	got := make(map[string]string)
+	for _, header := range headers {
+		got[header.key] = header.value
+	}
+	want := map[string]string{
+		"schema":      "shop",
+		"table":       "t_user",
+		"action":      "modify",
+		"timestamp":   "1602806400",
+		"seq":         "0",
+		"binlog_file": "mysql-bin.000003",
+		"binlog_pos":  "1234",
+		"gtid":        row.Gtid,
+		"tenant":      "t1",
+	}
+	if len(headers) != len(want) {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("header %s: want %s, got %s", k, v, got[k])
+		}
+	}
+}
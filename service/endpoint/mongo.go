@@ -57,7 +57,8 @@ type MongoEndpoint struct {
 func newMongoEndpoint() *MongoEndpoint {
 	addrList := strings.Split(global.Cfg().MongodbAddr, ",")
 	opts := &options.ClientOptions{
-		Hosts: addrList,
+		Hosts:     addrList,
+		TLSConfig: global.Cfg().TargetTLSConfig(),
 	}
 
 	if global.Cfg().MongodbUsername != "" && global.Cfg().MongodbPassword != "" {
@@ -91,6 +92,14 @@ func (s *MongoEndpoint) Connect() error {
 	return nil
 }
 
+// RefreshRules 规则热加载后，为新规则预先创建集合句柄
+func (s *MongoEndpoint) RefreshRules() error {
+	for _, rule := range global.RuleInsList() {
+		s.collection(s.collectionKey(rule.MongodbDatabase, rule.MongodbCollection))
+	}
+	return nil
+}
+
 func (s *MongoEndpoint) Ping() error {
 	return s.client.Ping(context.Background(), readpref.Primary())
 }
@@ -135,7 +144,10 @@ func (s *MongoEndpoint) Consume(from mysql.Position, rows []*model.RowRequest) e
 
 		if rule.LuaEnable() {
 			kvm := rowMap(row, rule, true)
+			span := luaSpan(row)
 			ls, err := luaengine.DoMongoOps(kvm, row.Action, rule)
+			span.SetError(err)
+			span.End()
 			if err != nil {
 				return errors.Errorf("lua 脚本执行失败 : %s ", errors.ErrorStack(err))
 			}
@@ -227,7 +239,10 @@ func (s *MongoEndpoint) Stock(rows []*model.RowRequest) int64 {
 
 		if rule.LuaEnable() {
 			kvm := rowMap(row, rule, true)
+			span := luaSpan(row)
 			ls, err := luaengine.DoMongoOps(kvm, row.Action, rule)
+			span.SetError(err)
+			span.End()
 			if err != nil {
 				log.Println("Lua 脚本执行失败!!! ,详情请参见日志")
 				logs.Errorf("lua 脚本执行失败 : %s ", errors.ErrorStack(err))
@@ -303,7 +318,10 @@ func (s *MongoEndpoint) doConsumeSlowly(rows []*model.RowRequest) (int64, error)
 
 		if rule.LuaEnable() {
 			kvm := rowMap(row, rule, true)
+			span := luaSpan(row)
 			ls, err := luaengine.DoMongoOps(kvm, row.Action, rule)
+			span.SetError(err)
+			span.End()
 			if err != nil {
 				logs.Errorf("lua 脚本执行失败 : %s ", errors.ErrorStack(err))
 				return sum, err
@@ -370,6 +388,90 @@ func (s *MongoEndpoint) doConsumeSlowly(rows []*model.RowRequest) (int64, error)
 	return sum, nil
 }
 
+func (s *MongoEndpoint) Fetch(rule *global.Rule, rows []*model.RowRequest) ([]*VerifyRecord, error) {
+	records := make([]*VerifyRecord, 0, len(rows))
+	if len(rows) == 0 {
+		return records, nil
+	}
+
+	ids := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		id := primaryKey(row, rule)
+		kvm := rowMap(row, rule, false)
+		kvm["_id"] = id
+		records = append(records, &VerifyRecord{
+			Key:      stringutil.ToString(id),
+			Expected: kvm,
+		})
+		ids = append(ids, id)
+	}
+
+	ctx := context.Background()
+	collection := s.collection(s.collectionKey(rule.MongodbDatabase, rule.MongodbCollection))
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer cursor.Close(ctx)
+
+	docs := make(map[string]bson.M, len(rows))
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, errors.Trace(err)
+		}
+		docs[stringutil.ToString(doc["_id"])] = doc
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, record := range records {
+		if doc, ok := docs[record.Key]; ok {
+			record.Actual = doc
+		}
+	}
+	return records, nil
+}
+
+func (s *MongoEndpoint) Keys(rule *global.Rule, fn func(keys []string) error) error {
+	if sharedTarget(rule, func(other *global.Rule) bool {
+		return other.MongodbDatabase == rule.MongodbDatabase && other.MongodbCollection == rule.MongodbCollection
+	}) {
+		return notSupportedf("collection %s.%s shared by multiple rules", rule.MongodbDatabase, rule.MongodbCollection)
+	}
+
+	ctx := context.Background()
+	collection := s.collection(s.collectionKey(rule.MongodbDatabase, rule.MongodbCollection))
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cursor.Close(ctx)
+
+	size := int(global.Cfg().BulkSize)
+	keys := make([]string, 0, size)
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return errors.Trace(err)
+		}
+		keys = append(keys, stringutil.ToString(doc["_id"]))
+		if len(keys) >= size {
+			if err := fn(keys); err != nil {
+				return err
+			}
+			keys = make([]string, 0, size)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return errors.Trace(err)
+	}
+	if len(keys) > 0 {
+		return fn(keys)
+	}
+	return nil
+}
+
 func (s *MongoEndpoint) Close() {
 	if s.client != nil {
 		s.client.Disconnect(context.Background())
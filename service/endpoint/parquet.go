@@ -0,0 +1,574 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/metrics"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/util/dates"
+	"go-mysql-transfer/util/files"
+	"go-mysql-transfer/util/logs"
+	"go-mysql-transfer/util/stringutil"
+)
+
+const (
+	_parquetInProgress   = ".inprogress"
+	_parquetManifestName = "_committed.json"
+	_parquetActionColumn = "_action"
+	_parquetTsColumn     = "_timestamp"
+
+	_parquetTypeInt64  = "INT64"
+	_parquetTypeDouble = "DOUBLE"
+	_parquetTypeString = "UTF8"
+	_parquetTypeBinary = "BYTE_ARRAY"
+)
+
+type parquetColumn struct {
+	name  string
+	kind  string
+	index int // 列在行中的位置，-1表示非表中的列
+}
+
+type parquetManifest struct {
+	Name  string   `json:"name"`
+	Pos   uint32   `json:"pos"`
+	Time  string   `json:"time"`
+	Files []string `json:"files"`
+}
+
+// parquetPart 一个正在写入的Parquet文件
+type parquetPart struct {
+	path     string
+	file     *os.File
+	writer   *writer.CSVWriter
+	columns  []*parquetColumn
+	openTime time.Time
+}
+
+type ParquetEndpoint struct {
+	lock      sync.Mutex
+	parts     map[string]*parquetPart
+	finished  []*parquetPart // 已写完但尚未提交的文件
+	committed bool
+	position  mysql.Position // 最后一次提交的位置
+	latest    mysql.Position // 最后一次调用Commit时的位置
+	consumed  mysql.Position // 最后一批增量数据的位置，可能晚于latest
+}
+
+func newParquetEndpoint() *ParquetEndpoint {
+	r := &ParquetEndpoint{}
+	r.parts = make(map[string]*parquetPart)
+	return r
+}
+
+func (s *ParquetEndpoint) Connect() error {
+	dir := global.Cfg().ParquetDir
+	if err := files.MkdirIfNecessary(dir); err != nil {
+		return err
+	}
+
+	// 全量导入等不调用Commit的场景，关闭时沿用上次提交的位置
+	manifest, err := readParquetManifest(dir)
+	if err != nil {
+		return err
+	}
+	if manifest != nil {
+		s.position = mysql.Position{Name: manifest.Name, Pos: manifest.Pos}
+		s.latest = s.position
+	}
+
+	// 清单已写入而重命名未完成时，按清单继续完成重命名
+	if manifest != nil {
+		for _, name := range manifest.Files {
+			target := filepath.Join(dir, name)
+			if !files.IsExist(target) && files.IsExist(target+_parquetInProgress) {
+				if err := os.Rename(target+_parquetInProgress, target); err != nil {
+					return errors.Trace(err)
+				}
+				if err := files.SyncDir(filepath.Dir(target)); err != nil {
+					return errors.Trace(err)
+				}
+				logs.Infof("parquet file committed: %s", target)
+			}
+		}
+	}
+
+	// 清单之外的未提交文件对应的binlog位置尚未保存，重启后会重新同步，直接删除
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, _parquetInProgress) {
+			logs.Infof("remove uncommitted parquet file: %s", path)
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+func (s *ParquetEndpoint) Ping() error {
+	if !files.IsDir(global.Cfg().ParquetDir) {
+		return errors.Errorf("parquet_dir %s not available", global.Cfg().ParquetDir)
+	}
+	return nil
+}
+
+func (s *ParquetEndpoint) Consume(from mysql.Position, rows []*model.RowRequest) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		metrics.UpdateActionNum(row.Action, row.RuleKey)
+
+		if err := s.write(row, rule); err != nil {
+			return err
+		}
+		if row.LogName != "" {
+			s.consumed = mysql.Position{Name: row.LogName, Pos: row.LogPos}
+		}
+	}
+
+	logs.Infof("处理完成 %d 条数据", len(rows))
+	return nil
+}
+
+func (s *ParquetEndpoint) Stock(rows []*model.RowRequest) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var sum int64
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		if err := s.write(row, rule); err != nil {
+			logs.Error(errors.ErrorStack(err))
+			break
+		}
+		sum++
+	}
+
+	return sum
+}
+
+// Commit 达到滚动条件时关闭全部文件并提交，提交成功后返回的位置才可以保存
+func (s *ParquetEndpoint) Commit(pos mysql.Position) (mysql.Position, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.latest = pos
+	if !s.shouldRoll() {
+		return s.position, s.committed, nil
+	}
+
+	if err := s.commit(pos); err != nil {
+		return s.position, s.committed, err
+	}
+
+	return s.position, s.committed, nil
+}
+
+func (s *ParquetEndpoint) shouldRoll() bool {
+	if len(s.finished) > 0 {
+		return true
+	}
+
+	rollSize := int64(global.Cfg().ParquetRollSize) * 1024 * 1024
+	interval := time.Duration(global.Cfg().ParquetRollInterval) * time.Minute
+	for _, part := range s.parts {
+		if part.size() >= rollSize || time.Since(part.openTime) >= interval {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commit 只通过清单发布文件：已刷盘的文件以.inprogress后缀暂存，先写入列出这些文件的清单并刷盘，
+// 再重命名；重命名中断时，Connect按清单继续完成，清单之外的暂存文件会被删除
+func (s *ParquetEndpoint) commit(pos mysql.Position) error {
+	for key, part := range s.parts {
+		if err := part.finish(); err != nil {
+			return err
+		}
+		s.finished = append(s.finished, part)
+		delete(s.parts, key)
+	}
+
+	dir := global.Cfg().ParquetDir
+	dirs := make(map[string]bool)
+	committedFiles := make([]string, 0, len(s.finished))
+	for _, part := range s.finished {
+		dirs[filepath.Dir(part.path)] = true
+		rel, _ := filepath.Rel(dir, strings.TrimSuffix(part.path, _parquetInProgress))
+		committedFiles = append(committedFiles, rel)
+	}
+	for d := range dirs { // 暂存文件的目录项先于清单落盘
+		if err := files.SyncDir(d); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if err := writeParquetManifest(dir, pos, committedFiles); err != nil {
+		return err
+	}
+	s.position = pos
+	s.committed = true
+
+	for len(s.finished) > 0 {
+		part := s.finished[0]
+		target := strings.TrimSuffix(part.path, _parquetInProgress)
+		if err := os.Rename(part.path, target); err != nil {
+			return errors.Trace(err)
+		}
+		s.finished = s.finished[1:]
+		logs.Infof("parquet file committed: %s", target)
+	}
+	for d := range dirs {
+		if err := files.SyncDir(d); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (s *ParquetEndpoint) write(row *model.RowRequest, rule *global.Rule) error {
+	ts := time.Now()
+	if row.Timestamp > 0 {
+		ts = time.Unix(int64(row.Timestamp), 0)
+	}
+	dt := ts.Format(dates.DayFormatter)
+	key := row.RuleKey + "/" + dt
+
+	part, ok := s.parts[key]
+	if ok && len(part.columns) != len(rule.PaddingMap)+len(rule.DefaultColumnValueMap)+2 {
+		// 表结构发生变化，结束当前文件，等待下次提交
+		if err := part.finish(); err != nil {
+			return err
+		}
+		s.finished = append(s.finished, part)
+		delete(s.parts, key)
+		ok = false
+	}
+
+	if !ok {
+		var err error
+		dir := filepath.Join(global.Cfg().ParquetDir, "schema="+rule.Schema, "table="+rule.Table, "dt="+dt)
+		part, err = newParquetPart(dir, rule)
+		if err != nil {
+			return err
+		}
+		s.parts[key] = part
+	}
+
+	return part.write(row, rule)
+}
+
+func (s *ParquetEndpoint) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.parts) == 0 && len(s.finished) == 0 {
+		return
+	}
+	// 最后一次Commit之后写入的数据，以其位置提交
+	pos := s.latest
+	if s.consumed.Compare(pos) > 0 {
+		pos = s.consumed
+	}
+	if err := s.commit(pos); err != nil {
+		logs.Error(errors.ErrorStack(err))
+	}
+}
+
+func newParquetPart(dir string, rule *global.Rule) (*parquetPart, error) {
+	if err := files.MkdirIfNecessary(dir); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("part-%s-%d.parquet", time.Now().Format(_fileRollLayout), time.Now().UnixNano()%1e9)
+	path := filepath.Join(dir, name+_parquetInProgress)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	columns := parquetColumns(rule)
+	pw, err := newParquetWriter(file, columns, global.Cfg().ParquetCompression,
+		int64(global.Cfg().ParquetRowGroupSize)*1024*1024)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	return &parquetPart{
+		path:     path,
+		file:     file,
+		writer:   pw,
+		columns:  columns,
+		openTime: time.Now(),
+	}, nil
+}
+
+func (s *parquetPart) write(row *model.RowRequest, rule *global.Rule) error {
+	return errors.Trace(s.writer.Write(parquetRecord(row, rule, s.columns)))
+}
+
+func (s *parquetPart) size() int64 {
+	return s.writer.Offset + s.writer.Size + s.writer.ObjsSize
+}
+
+// finish 写入footer并刷盘
+func (s *parquetPart) finish() error {
+	if err := s.writer.WriteStop(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.file.Close())
+}
+
+func newParquetWriter(w io.Writer, columns []*parquetColumn, compression string, rowGroupSize int64) (*writer.CSVWriter, error) {
+	md := make([]string, 0, len(columns))
+	for _, c := range columns {
+		md = append(md, fmt.Sprintf("name=%s, type=%s, repetitiontype=OPTIONAL", c.name, c.kind))
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	pw.RowGroupSize = rowGroupSize
+	switch compression {
+	case global.ParquetCompressionGzip:
+		pw.CompressionType = parquet.CompressionCodec_GZIP
+	case global.ParquetCompressionNone:
+		pw.CompressionType = parquet.CompressionCodec_UNCOMPRESSED
+	default:
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	}
+
+	return pw, nil
+}
+
+// parquetColumns 根据表结构推导Parquet schema
+func parquetColumns(rule *global.Rule) []*parquetColumn {
+	columns := []*parquetColumn{
+		{name: _parquetActionColumn, kind: _parquetTypeString, index: -1},
+		{name: _parquetTsColumn, kind: _parquetTypeInt64, index: -1},
+	}
+
+	for _, padding := range orderedPaddings(rule) {
+		columns = append(columns, &parquetColumn{
+			name:  padding.WrapName,
+			kind:  parquetKind(&rule.TableInfo.Columns[padding.ColumnIndex]),
+			index: padding.ColumnIndex,
+		})
+	}
+
+	for _, k := range sortedDefaultColumns(rule) {
+		columns = append(columns, &parquetColumn{
+			name:  rule.WrapName(k),
+			kind:  _parquetTypeString,
+			index: -1,
+		})
+	}
+
+	return columns
+}
+
+func parquetKind(column *schema.TableColumn) string {
+	switch column.Type {
+	case schema.TYPE_NUMBER:
+		if unsignedBigint(column) { // 超出INT64范围，以字符串保存
+			return _parquetTypeString
+		}
+		return _parquetTypeInt64
+	case schema.TYPE_MEDIUM_INT, schema.TYPE_BIT:
+		return _parquetTypeInt64
+	case schema.TYPE_FLOAT, schema.TYPE_DECIMAL:
+		return _parquetTypeDouble
+	case schema.TYPE_BINARY, schema.TYPE_POINT:
+		return _parquetTypeBinary
+	default:
+		return _parquetTypeString
+	}
+}
+
+func parquetRecord(row *model.RowRequest, rule *global.Rule, columns []*parquetColumn) []interface{} {
+	record := make([]interface{}, 0, len(columns))
+	record = append(record, row.Action, int64(row.Timestamp))
+	for _, c := range columns[2:] {
+		if c.index < 0 {
+			record = append(record, defaultColumnValue(rule, c.name))
+			continue
+		}
+		value := convertColumnData(row.Row[c.index], &rule.TableInfo.Columns[c.index], rule)
+		record = append(record, parquetValue(value, c.kind))
+	}
+	return record
+}
+
+func defaultColumnValue(rule *global.Rule, wrapName string) interface{} {
+	for k, v := range rule.DefaultColumnValueMap {
+		if rule.WrapName(k) == wrapName {
+			return v
+		}
+	}
+	return nil
+}
+
+func parquetValue(value interface{}, kind string) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch kind {
+	case _parquetTypeInt64:
+		switch v := value.(type) {
+		case int8:
+			return int64(v)
+		case int16:
+			return int64(v)
+		case int32:
+			return int64(v)
+		case int64:
+			return v
+		case int:
+			return int64(v)
+		case uint8:
+			return int64(v)
+		case uint16:
+			return int64(v)
+		case uint32:
+			return int64(v)
+		case uint64:
+			if v > math.MaxInt64 {
+				return nil
+			}
+			return int64(v)
+		case uint:
+			if uint64(v) > math.MaxInt64 {
+				return nil
+			}
+			return int64(v)
+		default:
+			i, err := strconv.ParseInt(stringutil.ToString(v), 10, 64)
+			if err != nil {
+				return nil
+			}
+			return i
+		}
+	case _parquetTypeDouble:
+		switch v := value.(type) {
+		case float64:
+			return v
+		case float32:
+			return float64(v)
+		default:
+			f, err := strconv.ParseFloat(stringutil.ToString(v), 64)
+			if err != nil {
+				return nil
+			}
+			return f
+		}
+	default:
+		return stringutil.ToString(value)
+	}
+}
+
+// readParquetManifest 读取最后一次提交的记录，不存在时返回nil
+func readParquetManifest(dir string) (*parquetManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, _parquetManifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var manifest parquetManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Annotatef(err, "parse %s", _parquetManifestName)
+	}
+	return &manifest, nil
+}
+
+// writeParquetManifest 以先写临时文件、刷盘再重命名的方式原子地记录已提交的文件和binlog位置
+func writeParquetManifest(dir string, pos mysql.Position, committedFiles []string) error {
+	manifest := parquetManifest{
+		Name:  pos.Name,
+		Pos:   pos.Pos,
+		Time:  dates.NowFormatted(),
+		Files: committedFiles,
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	tmp := filepath.Join(dir, _parquetManifestName+".tmp")
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return errors.Trace(err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Trace(err)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, _parquetManifestName)); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(files.SyncDir(dir))
+}
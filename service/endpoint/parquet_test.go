@@ -0,0 +1,197 @@
+package endpoint
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+)
+
+func TestParquetWriterRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	columns := []*parquetColumn{
+		{name: _parquetActionColumn, kind: _parquetTypeString, index: -1},
+		{name: _parquetTsColumn, kind: _parquetTypeInt64, index: -1},
+		{name: "id", kind: _parquetTypeInt64, index: 0},
+		{name: "score", kind: _parquetTypeDouble, index: 1},
+		{name: "name", kind: _parquetTypeString, index: 2},
+	}
+
+	path := filepath.Join(dir, "part.parquet")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := newParquetWriter(file, columns, global.ParquetCompressionSnappy, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		record := []interface{}{
+			"insert",
+			int64(1602806400),
+			parquetValue(int32(i), _parquetTypeInt64),
+			parquetValue("9.5", _parquetTypeDouble),
+			parquetValue(nil, _parquetTypeString),
+		}
+		if err := pw.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	pf, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+	pr, err := reader.NewParquetReader(pf, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	if pr.GetNumRows() != 10 {
+		t.Errorf("expected 10 rows, got %d", pr.GetNumRows())
+	}
+	if n := len(pr.SchemaHandler.SchemaElements); n != len(columns)+1 {
+		t.Errorf("expected %d schema elements, got %d", len(columns)+1, n)
+	}
+}
+
+func TestParquetKind(t *testing.T) {
+	signed := &schema.TableColumn{Name: "id", Type: schema.TYPE_NUMBER, RawType: "bigint(20)"}
+	unsigned := &schema.TableColumn{Name: "id", Type: schema.TYPE_NUMBER, RawType: "bigint(20) unsigned", IsUnsigned: true}
+	if kind := parquetKind(signed); kind != _parquetTypeInt64 {
+		t.Errorf("bigint mapped to %s", kind)
+	}
+	if kind := parquetKind(unsigned); kind != _parquetTypeString {
+		t.Errorf("unsigned bigint mapped to %s", kind)
+	}
+	if v := parquetValue(uint64(math.MaxUint64), _parquetTypeString); v != "18446744073709551615" {
+		t.Errorf("unexpected unsigned bigint value: %v", v)
+	}
+	if v := parquetValue(uint64(math.MaxUint64), _parquetTypeInt64); v != nil {
+		t.Errorf("overflowed value should be null: %v", v)
+	}
+}
+
+func TestParquetEndpointCloseCommitsLatestPosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	global.ReplaceConfig(&global.Config{
+		ParquetDir:          dir,
+		ParquetCompression:  global.ParquetCompressionSnappy,
+		ParquetRowGroupSize: 1,
+		ParquetRollSize:     128,
+		ParquetRollInterval: 10,
+	})
+	rule := testMessageRule()
+	rule.TableColumnSize = len(rule.TableInfo.Columns)
+	global.AddRuleIns("shop:t_user", rule)
+
+	ep := newParquetEndpoint()
+	if err := ep.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Consume(mysql.Position{}, []*model.RowRequest{testUpdateRequest()}); err != nil {
+		t.Fatal(err)
+	}
+	if pos, ok, err := ep.Commit(mysql.Position{Name: "mysql-bin.000003", Pos: 1234}); err != nil || ok || pos.Name != "" {
+		t.Fatalf("commit before roll: %v %v %v", pos, ok, err)
+	}
+	// 最后一次Commit之后写入的数据，关闭时以其位置提交
+	row := testUpdateRequest()
+	row.LogPos = 2048
+	if err := ep.Consume(mysql.Position{}, []*model.RowRequest{row}); err != nil {
+		t.Fatal(err)
+	}
+	latest := mysql.Position{Name: "mysql-bin.000003", Pos: 2048}
+	ep.Close()
+
+	manifest, err := readParquetManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest == nil || manifest.Name != latest.Name || manifest.Pos != latest.Pos {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if len(manifest.Files) != 1 || !strings.HasPrefix(filepath.ToSlash(manifest.Files[0]), "schema=shop/table=t_user/dt=") {
+		t.Fatalf("unexpected files: %v", manifest.Files)
+	}
+
+	// 全量导入不调用Commit，关闭时沿用上次提交的位置
+	stock := newParquetEndpoint()
+	if err := stock.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if n := stock.Stock([]*model.RowRequest{testUpdateRequest()}); n != 1 {
+		t.Fatalf("stock %d rows", n)
+	}
+	stock.Close()
+	if manifest, _ = readParquetManifest(dir); manifest.Name != latest.Name || manifest.Pos != latest.Pos {
+		t.Fatalf("stock close changed position: %+v", manifest)
+	}
+}
+
+func TestParquetEndpointConnectCompletesManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	global.ReplaceConfig(&global.Config{ParquetDir: dir})
+
+	// 清单已写入、重命名前中断
+	partition := filepath.Join(dir, "schema=shop", "table=t_user", "dt=2020-10-16")
+	if err := os.MkdirAll(partition, 0755); err != nil {
+		t.Fatal(err)
+	}
+	listed := filepath.Join(partition, "part-1.parquet")
+	unlisted := filepath.Join(partition, "part-2.parquet")
+	for _, path := range []string{listed, unlisted} {
+		if err := ioutil.WriteFile(path+_parquetInProgress, []byte("PAR1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rel, _ := filepath.Rel(dir, listed)
+	if err := writeParquetManifest(dir, mysql.Position{Name: "mysql-bin.000003", Pos: 1234}, []string{rel}); err != nil {
+		t.Fatal(err)
+	}
+
+	ep := newParquetEndpoint()
+	if err := ep.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(listed); err != nil {
+		t.Errorf("listed file not committed: %v", err)
+	}
+	if _, err := os.Stat(unlisted + _parquetInProgress); !os.IsNotExist(err) {
+		t.Errorf("unlisted file not removed: %v", err)
+	}
+	if _, err := os.Stat(unlisted); !os.IsNotExist(err) {
+		t.Errorf("unlisted file published: %v", err)
+	}
+}
@@ -18,7 +18,6 @@
 package endpoint
 
 import (
-	"github.com/siddontang/go-mysql/canal"
 	"log"
 	"strconv"
 
@@ -39,11 +38,13 @@ type RabbitEndpoint struct {
 	rabChl    *amqp.Channel
 	queues    map[string]bool
 	serverUrl string
+	registry  *schemaRegistry
 }
 
 func newRabbitEndpoint() *RabbitEndpoint {
 	r := &RabbitEndpoint{}
 	r.queues = make(map[string]bool)
+	r.registry = newTargetSchemaRegistry()
 	return r
 }
 
@@ -57,7 +58,13 @@ func (s *RabbitEndpoint) Connect() error {
 		s.rabCon = nil
 	}
 
-	con, err := amqp.Dial(global.Cfg().RabbitmqAddr)
+	var con *amqp.Connection
+	var err error
+	if tlsConfig := global.Cfg().TargetTLSConfig(); tlsConfig != nil {
+		con, err = amqp.DialTLS(global.Cfg().RabbitmqAddr, tlsConfig)
+	} else {
+		con, err = amqp.Dial(global.Cfg().RabbitmqAddr)
+	}
 	if err != nil {
 		return err
 	}
@@ -89,6 +96,23 @@ func (s *RabbitEndpoint) Connect() error {
 	return nil
 }
 
+// RefreshRules 规则热加载后，声明新规则的队列
+func (s *RabbitEndpoint) RefreshRules() error {
+	for _, rule := range global.RuleInsList() {
+		if s.queues[rule.RabbitmqQueue] {
+			continue
+		}
+		_, err := s.rabChl.QueueDeclare(
+			rule.RabbitmqQueue, false, false, false, false, nil,
+		)
+		if err != nil {
+			return err
+		}
+		s.queues[rule.RabbitmqQueue] = true
+	}
+	return nil
+}
+
 func (s *RabbitEndpoint) Ping() error {
 	_, err := nets.IsActiveTCPAddr(s.serverUrl)
 	return err
@@ -161,7 +185,10 @@ func (s *RabbitEndpoint) Stock(rows []*model.RowRequest) int64 {
 
 func (s *RabbitEndpoint) doLuaConsume(req *model.RowRequest, rule *global.Rule) error {
 	kvm := rowMap(req, rule, true)
+	span := luaSpan(req)
 	ls, err := luaengine.DoMQOps(kvm, req.Action, rule)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		log.Println("Lua 脚本执行失败!!! ,详情请参见日志")
 		return errors.Errorf("lua 脚本执行失败 : %s ", err)
@@ -171,6 +198,7 @@ func (s *RabbitEndpoint) doLuaConsume(req *model.RowRequest, rule *global.Rule)
 		s.mergeQueue(resp.Topic)
 		err := s.rabChl.Publish("", resp.Topic, false, false,
 			amqp.Publishing{
+				Headers:     rabbitHeaders(messageHeaders(req, rule, resp.Headers)),
 				ContentType: "text/plain",
 				Body:        resp.ByteArray,
 			})
@@ -185,36 +213,48 @@ func (s *RabbitEndpoint) doLuaConsume(req *model.RowRequest, rule *global.Rule)
 }
 
 func (s *RabbitEndpoint) doRuleConsume(req *model.RowRequest, rule *global.Rule) error {
-	kvm := rowMap(req, rule, false)
-
-	resp := new(model.MQRespond)
-	resp.Action = req.Action
-	resp.Timestamp = req.Timestamp
-	if rule.ValueEncoder == global.ValEncoderJson {
-		resp.Date = kvm
-	} else {
-		resp.Date = encodeValue(rule, kvm)
-	}
-
-	if rule.ReserveRawData && canal.UpdateAction == req.Action {
-		resp.Raw = oldRowMap(req, rule, false)
-	}
-
-	body, err := json.Marshal(resp)
+	payload, err := mqMessage(rule.RabbitmqQueue, req, rule, s.registry)
 	if err != nil {
 		return err
 	}
+	contentType := "text/plain"
+	if payload.contentType != "" {
+		contentType = payload.contentType
+	}
+	headers := rabbitHeaders(payload.headers)
+	// CloudEvents AMQP协议绑定：属性使用cloudEvents:前缀的application-properties
+	if len(payload.attributes) > 0 {
+		if headers == nil {
+			headers = make(amqp.Table, len(payload.attributes))
+		}
+		for _, attr := range payload.attributes {
+			headers["cloudEvents:"+attr.key] = attr.value
+		}
+	}
 	err = s.rabChl.Publish("", rule.RabbitmqQueue, false, false,
 		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        body,
+			Headers:     headers,
+			ContentType: contentType,
+			Body:        payload.body,
 		})
 
-	logs.Infof("topic: %s, message: %s", rule.RabbitmqQueue, string(body))
+	logs.Infof("topic: %s, message: %s", rule.RabbitmqQueue, string(payload.body))
 
 	return err
 }
 
+func rabbitHeaders(headers []mqHeader) amqp.Table {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	table := make(amqp.Table, len(headers))
+	for _, header := range headers {
+		table[header.key] = header.value
+	}
+	return table
+}
+
 func (s *RabbitEndpoint) Close() {
 	if s.rabChl != nil {
 		s.rabChl.Close()
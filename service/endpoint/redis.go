@@ -50,9 +50,10 @@ func newRedisEndpoint() *RedisEndpoint {
 	list := strings.Split(cfg.RedisAddr, ",")
 	if len(list) == 1 {
 		r.client = redis.NewClient(&redis.Options{
-			Addr:     cfg.RedisAddr,
-			Password: cfg.RedisPass,
-			DB:       cfg.RedisDatabase,
+			Addr:      cfg.RedisAddr,
+			Password:  cfg.RedisPass,
+			DB:        cfg.RedisDatabase,
+			TLSConfig: cfg.TargetTLSConfig(),
 		})
 	} else {
 		if cfg.RedisGroupType == global.RedisGroupTypeSentinel {
@@ -61,13 +62,15 @@ func newRedisEndpoint() *RedisEndpoint {
 				SentinelAddrs: list,
 				Password:      cfg.RedisPass,
 				DB:            cfg.RedisDatabase,
+				TLSConfig:     cfg.TargetTLSConfig(),
 			})
 		}
 		if cfg.RedisGroupType == global.RedisGroupTypeCluster {
 			r.isCluster = true
 			r.cluster = redis.NewClusterClient(&redis.ClusterOptions{
-				Addrs:    list,
-				Password: cfg.RedisPass,
+				Addrs:     list,
+				Password:  cfg.RedisPass,
+				TLSConfig: cfg.TargetTLSConfig(),
 			})
 		}
 	}
@@ -114,12 +117,15 @@ func (s *RedisEndpoint) Consume(from mysql.Position, rows []*model.RowRequest) e
 			var err error
 			var ls []*model.RedisRespond
 			kvm := rowMap(row, rule, true)
+			span := luaSpan(row)
 			if row.Action == canal.UpdateAction {
 				previous := oldRowMap(row, rule, true)
 				ls, err = luaengine.DoRedisOps(kvm, previous, row.Action, rule)
 			} else {
 				ls, err = luaengine.DoRedisOps(kvm, nil, row.Action, rule)
 			}
+			span.SetError(err)
+			span.End()
 			if err != nil {
 				log.Println("Lua 脚本执行失败!!! ,详情请参见日志")
 				return errors.Errorf("Lua 脚本执行失败 : %s ", errors.ErrorStack(err))
@@ -156,7 +162,10 @@ func (s *RedisEndpoint) Stock(rows []*model.RowRequest) int64 {
 
 		if rule.LuaEnable() {
 			kvm := rowMap(row, rule, true)
+			span := luaSpan(row)
 			ls, err := luaengine.DoRedisOps(kvm, nil, row.Action, rule)
+			span.SetError(err)
+			span.End()
 			if err != nil {
 				logs.Errorf("lua 脚本执行失败 : %s ", errors.ErrorStack(err))
 				break
@@ -326,6 +335,155 @@ func (s *RedisEndpoint) encodeSortedSetScoreField(req *model.RowRequest, rule *g
 	return stringutil.ToFloat64Safe(str)
 }
 
+// cmdable 单机、哨兵及集群模式统一的命令接口
+func (s *RedisEndpoint) cmdable() redis.Cmdable {
+	if s.isCluster {
+		return s.cluster
+	}
+	return s.client
+}
+
+// Fetch string、hash结构按key(hash为key/field)读取值，set、sorted set结构按成员检查是否存在，不支持list结构
+func (s *RedisEndpoint) Fetch(rule *global.Rule, rows []*model.RowRequest) ([]*VerifyRecord, error) {
+	if rule.RedisStructure == global.RedisStructureList {
+		return nil, notSupportedf("verify redis list structure")
+	}
+
+	records := make([]*VerifyRecord, 0, len(rows))
+	if len(rows) == 0 {
+		return records, nil
+	}
+
+	pipe := s.pipe()
+	cmds := make([]redis.Cmder, 0, len(rows))
+	for _, row := range rows {
+		key := s.encodeKey(row, rule)
+		val := encodeValue(rule, rowMap(row, rule, false))
+		switch rule.RedisStructure {
+		case global.RedisStructureString:
+			records = append(records, &VerifyRecord{Key: key, Expected: val})
+			cmds = append(cmds, pipe.Get(key))
+		case global.RedisStructureHash:
+			field := s.encodeHashField(row, rule)
+			records = append(records, &VerifyRecord{Key: key + "/" + field, Expected: val})
+			cmds = append(cmds, pipe.HGet(key, field))
+		case global.RedisStructureSet:
+			records = append(records, &VerifyRecord{Key: val, Expected: val})
+			cmds = append(cmds, pipe.SIsMember(key, val))
+		case global.RedisStructureSortedSet:
+			records = append(records, &VerifyRecord{Key: val, Expected: s.encodeSortedSetScoreField(row, rule)})
+			cmds = append(cmds, pipe.ZScore(key, val))
+		}
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, errors.Trace(err)
+	}
+
+	for i, cmd := range cmds {
+		if cmd.Err() == redis.Nil {
+			continue
+		}
+		if cmd.Err() != nil {
+			return nil, errors.Trace(cmd.Err())
+		}
+		switch c := cmd.(type) {
+		case *redis.StringCmd:
+			records[i].Actual = c.Val()
+		case *redis.BoolCmd:
+			if c.Val() {
+				records[i].Actual = records[i].Expected
+			}
+		case *redis.FloatCmd:
+			records[i].Actual = c.Val()
+		}
+	}
+	return records, nil
+}
+
+// Keys string结构需配置redis_key_prefix，hash、set、sorted set结构需配置redis_key_value；集群模式下不支持string结构
+func (s *RedisEndpoint) Keys(rule *global.Rule, fn func(keys []string) error) error {
+	size := global.Cfg().BulkSize
+	switch rule.RedisStructure {
+	case global.RedisStructureString:
+		if s.isCluster || rule.RedisKeyPrefix == "" || rule.RedisKeyValue != "" || rule.RedisKeyFormatter != "" {
+			return notSupportedf("scan redis keys without redis_key_prefix or in cluster mode")
+		}
+		if sharedTarget(rule, func(other *global.Rule) bool {
+			if other.RedisStructure != global.RedisStructureString || other.RedisKeyValue != "" {
+				return false
+			}
+			return strings.HasPrefix(other.RedisKeyPrefix, rule.RedisKeyPrefix) || strings.HasPrefix(rule.RedisKeyPrefix, other.RedisKeyPrefix)
+		}) {
+			return notSupportedf("redis_key_prefix %s shared by multiple rules", rule.RedisKeyPrefix)
+		}
+		return s.scan(func(cursor uint64) *redis.ScanCmd {
+			return s.client.Scan(cursor, escapeRedisPattern(rule.RedisKeyPrefix)+"*", size)
+		}, 1, "", fn)
+	case global.RedisStructureHash, global.RedisStructureSet, global.RedisStructureSortedSet:
+		if rule.RedisKeyValue == "" {
+			return notSupportedf("scan redis %s without redis_key_value", rule.RedisStructure)
+		}
+		if sharedTarget(rule, func(other *global.Rule) bool { return other.RedisKeyValue == rule.RedisKeyValue }) {
+			return notSupportedf("redis key %s shared by multiple rules", rule.RedisKeyValue)
+		}
+		key := rule.RedisKeyValue
+		switch rule.RedisStructure {
+		case global.RedisStructureHash:
+			// HSCAN返回field、value交替的列表
+			return s.scan(func(cursor uint64) *redis.ScanCmd {
+				return s.cmdable().HScan(key, cursor, "", size)
+			}, 2, key+"/", fn)
+		case global.RedisStructureSet:
+			return s.scan(func(cursor uint64) *redis.ScanCmd {
+				return s.cmdable().SScan(key, cursor, "", size)
+			}, 1, "", fn)
+		default:
+			// ZSCAN返回member、score交替的列表
+			return s.scan(func(cursor uint64) *redis.ScanCmd {
+				return s.cmdable().ZScan(key, cursor, "", size)
+			}, 2, "", fn)
+		}
+	}
+	return notSupportedf("verify redis %s structure", rule.RedisStructure)
+}
+
+// scan 按游标遍历，step为结果中每项数据占用的元素个数，prefix附加在每项数据之前
+func (s *RedisEndpoint) scan(next func(cursor uint64) *redis.ScanCmd, step int, prefix string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		ls, c, err := next(cursor).Result()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		keys := make([]string, 0, len(ls)/step)
+		for i := 0; i < len(ls); i += step {
+			keys = append(keys, prefix+ls[i])
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if c == 0 {
+			return nil
+		}
+		cursor = c
+	}
+}
+
+// escapeRedisPattern 转义SCAN MATCH中的通配符
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
 func (s *RedisEndpoint) Close() {
 	if s.client != nil {
 		s.client.Close()
@@ -19,7 +19,6 @@ package endpoint
 
 import (
 	"context"
-	"github.com/siddontang/go-mysql/canal"
 	"log"
 	"strings"
 	"sync"
@@ -43,6 +42,7 @@ const _rocketRetry = 2
 
 type RocketEndpoint struct {
 	client    rocketmq.Producer
+	registry  *schemaRegistry
 	retryLock sync.Mutex
 }
 
@@ -70,6 +70,7 @@ func newRocketEndpoint() *RocketEndpoint {
 	producer, _ := rocketmq.NewProducer(options...)
 	r := &RocketEndpoint{}
 	r.client = producer
+	r.registry = newTargetSchemaRegistry()
 	return r
 }
 
@@ -204,7 +205,10 @@ func (s *RocketEndpoint) Stock(rows []*model.RowRequest) int64 {
 
 func (s *RocketEndpoint) buildMessages(req *model.RowRequest, rule *global.Rule) ([]*primitive.Message, error) {
 	kvm := rowMap(req, rule, true)
+	span := luaSpan(req)
 	ls, err := luaengine.DoMQOps(kvm, req.Action, rule)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		return nil, errors.Errorf("lua 脚本执行失败 : %s ", err)
 	}
@@ -215,6 +219,9 @@ func (s *RocketEndpoint) buildMessages(req *model.RowRequest, rule *global.Rule)
 			Topic: resp.Topic,
 			Body:  resp.ByteArray,
 		}
+		for _, header := range messageHeaders(req, rule, resp.Headers) {
+			m.WithProperty(header.key, header.value)
+		}
 		logs.Infof("topic: %s, message: %s", m.Topic, string(m.Body))
 		ms = append(ms, m)
 	}
@@ -223,28 +230,24 @@ func (s *RocketEndpoint) buildMessages(req *model.RowRequest, rule *global.Rule)
 }
 
 func (s *RocketEndpoint) buildMessage(req *model.RowRequest, rule *global.Rule) (*primitive.Message, error) {
-	kvm := rowMap(req, rule, false)
-	resp := new(model.MQRespond)
-	resp.Action = req.Action
-	resp.Timestamp = req.Timestamp
-	if rule.ValueEncoder == global.ValEncoderJson {
-		resp.Date = kvm
-	} else {
-		resp.Date = encodeValue(rule, kvm)
-	}
-
-	if rule.ReserveRawData && canal.UpdateAction == req.Action {
-		resp.Raw = oldRowMap(req, rule, false)
-	}
-
-	body, err := json.Marshal(resp)
+	payload, err := mqMessage(rule.RocketmqTopic, req, rule, s.registry)
 	if err != nil {
 		return nil, err
 	}
 
 	m := &primitive.Message{
 		Topic: rule.RocketmqTopic,
-		Body:  body,
+		Body:  payload.body,
+	}
+	for _, header := range payload.headers {
+		m.WithProperty(header.key, header.value)
+	}
+	// CloudEvents RocketMQ协议绑定：属性使用CE_前缀的消息属性
+	for _, attr := range payload.attributes {
+		m.WithProperty("CE_"+attr.key, attr.value)
+	}
+	if len(payload.attributes) > 0 {
+		m.WithProperty("CE_datacontenttype", payload.contentType)
 	}
 
 	logs.Infof("topic: %s, message: %s", m.Topic, string(m.Body))
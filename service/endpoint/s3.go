@@ -0,0 +1,364 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/metrics"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/util/dates"
+	"go-mysql-transfer/util/logs"
+)
+
+// s3KeyVars 对象key前缀模板中可使用的变量
+type s3KeyVars struct {
+	Schema string
+	Table  string
+	Date   string
+	Hour   string
+}
+
+// s3Object 一个正在内存中缓存、尚未上传的对象
+type s3Object struct {
+	id       string // 缓存的标识：规则+key前缀
+	prefix   string
+	buf      *bytes.Buffer
+	writer   *writer.CSVWriter // parquet格式时不为空
+	columns  []*parquetColumn
+	openTime time.Time
+	rows     int
+	finished bool // 已结束写入，等待上传
+}
+
+type S3Endpoint struct {
+	lock      sync.Mutex
+	client    *s3.S3
+	uploader  *s3manager.Uploader
+	prefix    *template.Template
+	objects   map[string]*s3Object
+	committed bool
+	position  mysql.Position
+}
+
+func newS3Endpoint() *S3Endpoint {
+	r := &S3Endpoint{}
+	r.objects = make(map[string]*s3Object)
+	return r
+}
+
+func (s *S3Endpoint) Connect() error {
+	cfg := global.Cfg()
+
+	prefix, err := template.New("s3_key_prefix").Parse(cfg.S3KeyPrefix)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.prefix = prefix
+
+	awsCfg := aws.NewConfig().
+		WithRegion(cfg.S3Region).
+		WithS3ForcePathStyle(cfg.S3PathStyle)
+	if cfg.S3Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.S3Endpoint)
+	}
+	if cfg.S3AccessKey != "" {
+		awsCfg = awsCfg.WithCredentials(credentials.NewStaticCredentials(cfg.S3AccessKey, cfg.S3SecretKey, ""))
+	}
+	if transport := tlsTransport(); transport != nil {
+		awsCfg = awsCfg.WithHTTPClient(&http.Client{Transport: transport})
+	}
+
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.client = s3.New(sess)
+	s.uploader = s3manager.NewUploaderWithClient(s.client, func(u *s3manager.Uploader) {
+		u.PartSize = int64(cfg.S3PartSize) * 1024 * 1024
+	})
+
+	return s.Ping()
+}
+
+func (s *S3Endpoint) Ping() error {
+	_, err := s.client.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(global.Cfg().S3Bucket),
+	})
+	return errors.Trace(err)
+}
+
+func (s *S3Endpoint) Consume(from mysql.Position, rows []*model.RowRequest) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		metrics.UpdateActionNum(row.Action, row.RuleKey)
+
+		if _, err := s.write(row, rule); err != nil {
+			return err
+		}
+	}
+
+	logs.Infof("处理完成 %d 条数据", len(rows))
+	return nil
+}
+
+func (s *S3Endpoint) Stock(rows []*model.RowRequest) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rollSize := int64(global.Cfg().S3RollSize) * 1024 * 1024
+	var sum int64
+	for _, row := range rows {
+		rule, _ := global.RuleIns(row.RuleKey)
+		if rule.TableColumnSize != len(row.Row) {
+			logs.Warnf("%s schema mismatching", row.RuleKey)
+			continue
+		}
+
+		obj, err := s.write(row, rule)
+		if err != nil {
+			logs.Error(errors.ErrorStack(err))
+			break
+		}
+		sum++
+
+		// 全量同步没有binlog位置需要保存，对象写满后直接上传
+		if obj.size() >= rollSize {
+			if err := s.upload(obj); err != nil {
+				logs.Error(errors.ErrorStack(err))
+				break
+			}
+		}
+	}
+
+	return sum
+}
+
+// Commit 达到滚动条件时上传全部缓存的对象，全部上传成功后返回的位置才可以保存
+func (s *S3Endpoint) Commit(pos mysql.Position) (mysql.Position, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.shouldRoll() {
+		return s.position, s.committed, nil
+	}
+
+	if err := s.uploadAll(); err != nil {
+		return s.position, s.committed, err
+	}
+
+	s.position = pos
+	s.committed = true
+	return s.position, s.committed, nil
+}
+
+func (s *S3Endpoint) shouldRoll() bool {
+	rollSize := int64(global.Cfg().S3RollSize) * 1024 * 1024
+	interval := time.Duration(global.Cfg().S3RollInterval) * time.Minute
+	for _, obj := range s.objects {
+		if obj.size() >= rollSize || time.Since(obj.openTime) >= interval {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadAll 上传失败的对象保留在缓存中，下次Commit或Close时以相同的key重新上传
+func (s *S3Endpoint) uploadAll() error {
+	for _, obj := range s.objects {
+		if err := s.upload(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upload 上传成功后才从缓存中移除
+func (s *S3Endpoint) upload(obj *s3Object) error {
+	if !obj.finished {
+		if err := obj.finish(); err != nil {
+			return err
+		}
+		obj.finished = true
+	}
+
+	name := obj.name()
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(global.Cfg().S3Bucket),
+		Key:    aws.String(name),
+		Body:   bytes.NewReader(obj.buf.Bytes()),
+	})
+	if err != nil {
+		return errors.Annotatef(err, "upload %s", name)
+	}
+
+	delete(s.objects, obj.id)
+	logs.Infof("s3 object uploaded: %s, rows: %d", name, obj.rows)
+	return nil
+}
+
+func (s *S3Endpoint) write(row *model.RowRequest, rule *global.Rule) (*s3Object, error) {
+	prefix, err := s.keyPrefix(row, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	id := row.RuleKey + "@" + prefix
+	obj, ok := s.objects[id]
+	if ok && (obj.finished || (obj.writer != nil && len(obj.columns) != len(rule.PaddingMap)+len(rule.DefaultColumnValueMap)+2)) {
+		// 表结构发生变化，结束当前对象，以新的schema写入下一个对象
+		if err := s.upload(obj); err != nil {
+			return nil, err
+		}
+		ok = false
+	}
+
+	if !ok {
+		obj, err = newS3Object(id, prefix, rule)
+		if err != nil {
+			return nil, err
+		}
+		s.objects[id] = obj
+	}
+
+	if err := obj.write(row, rule); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// keyPrefix 根据前缀模板计算对象的key前缀
+func (s *S3Endpoint) keyPrefix(row *model.RowRequest, rule *global.Rule) (string, error) {
+	ts := time.Now()
+	if row.Timestamp > 0 {
+		ts = time.Unix(int64(row.Timestamp), 0)
+	}
+
+	var buf bytes.Buffer
+	err := s.prefix.Execute(&buf, &s3KeyVars{
+		Schema: rule.Schema,
+		Table:  rule.Table,
+		Date:   ts.Format(dates.DayFormatter),
+		Hour:   ts.Format("15"),
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	return strings.Trim(buf.String(), "/"), nil
+}
+
+func (s *S3Endpoint) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.objects) == 0 {
+		return
+	}
+	if err := s.uploadAll(); err != nil {
+		logs.Error(errors.ErrorStack(err))
+	}
+}
+
+func newS3Object(id, prefix string, rule *global.Rule) (*s3Object, error) {
+	obj := &s3Object{
+		id:       id,
+		prefix:   prefix,
+		buf:      new(bytes.Buffer),
+		openTime: time.Now(),
+	}
+
+	if global.Cfg().S3Format == global.S3FormatParquet {
+		obj.columns = parquetColumns(rule)
+		pw, err := newParquetWriter(obj.buf, obj.columns, global.Cfg().ParquetCompression,
+			int64(global.Cfg().ParquetRowGroupSize)*1024*1024)
+		if err != nil {
+			return nil, err
+		}
+		obj.writer = pw
+	}
+
+	return obj, nil
+}
+
+func (s *s3Object) write(row *model.RowRequest, rule *global.Rule) error {
+	s.rows++
+	if s.writer != nil {
+		return errors.Trace(s.writer.Write(parquetRecord(row, rule, s.columns)))
+	}
+
+	line, err := jsonLine(row, rule)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.buf.Write(line)
+	s.buf.WriteByte('\n')
+	return nil
+}
+
+func (s *s3Object) size() int64 {
+	if s.writer != nil {
+		return s.writer.Offset + s.writer.Size + s.writer.ObjsSize
+	}
+	return int64(s.buf.Len())
+}
+
+// finish parquet格式时写入footer
+func (s *s3Object) finish() error {
+	if s.writer == nil {
+		return nil
+	}
+	return errors.Trace(s.writer.WriteStop())
+}
+
+func (s *s3Object) name() string {
+	ext := ".jsonl"
+	if s.writer != nil {
+		ext = ".parquet"
+	}
+	name := fmt.Sprintf("part-%s-%d%s", s.openTime.Format(_fileRollLayout), s.openTime.UnixNano()%1e9, ext)
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
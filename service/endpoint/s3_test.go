@@ -0,0 +1,165 @@
+package endpoint
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/siddontang/go-mysql/mysql"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+)
+
+// s3StandIn 模拟S3的HeadBucket及PutObject
+type s3StandIn struct {
+	lock    sync.Mutex
+	fail    bool
+	objects map[string]string
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch r.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		if s.fail {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>denied</Message></Error>`))
+			return
+		}
+		s.objects[strings.TrimPrefix(r.URL.Path, "/transfer/")] = string(body)
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *s3StandIn) setFail(fail bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fail = fail
+}
+
+func (s *s3StandIn) keys() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func testS3Endpoint(t *testing.T) (*S3Endpoint, *s3StandIn) {
+	standIn := &s3StandIn{objects: make(map[string]string)}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	global.ReplaceConfig(&global.Config{
+		S3Endpoint:     server.URL,
+		S3Region:       "us-east-1",
+		S3Bucket:       "transfer",
+		S3AccessKey:    "minioadmin",
+		S3SecretKey:    "minioadmin",
+		S3PathStyle:    true,
+		S3Format:       global.S3FormatJson,
+		S3KeyPrefix:    "{{.Schema}}/{{.Table}}/dt={{.Date}}/hour={{.Hour}}",
+		S3PartSize:     5,
+		S3RollSize:     64,
+		S3RollInterval: 10,
+	})
+	rule := testMessageRule()
+	rule.TableColumnSize = len(rule.TableInfo.Columns)
+	global.AddRuleIns("shop:t_user", rule)
+
+	ep := newS3Endpoint()
+	if err := ep.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return ep, standIn
+}
+
+func TestS3KeyPrefix(t *testing.T) {
+	ep, _ := testS3Endpoint(t)
+
+	row := testUpdateRequest()
+	prefix, err := ep.keyPrefix(row, testMessageRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(int64(row.Timestamp), 0)
+	want := "shop/t_user/dt=" + ts.Format("2006-01-02") + "/hour=" + ts.Format("15")
+	if prefix != want {
+		t.Errorf("prefix %s, want %s", prefix, want)
+	}
+}
+
+func TestS3CommitAfterUpload(t *testing.T) {
+	ep, standIn := testS3Endpoint(t)
+
+	if err := ep.Consume(mysql.Position{}, []*model.RowRequest{testUpdateRequest()}); err != nil {
+		t.Fatal(err)
+	}
+	first := mysql.Position{Name: "mysql-bin.000003", Pos: 1234}
+	if pos, ok, err := ep.Commit(first); err != nil || ok || pos.Name != "" {
+		t.Fatalf("commit before roll: %v %v %v", pos, ok, err)
+	}
+
+	// 上传失败时位置不前进，缓存的数据保留
+	global.Cfg().S3RollInterval = 0
+	standIn.setFail(true)
+	second := mysql.Position{Name: "mysql-bin.000003", Pos: 2048}
+	if pos, ok, err := ep.Commit(second); err == nil || ok || pos.Name != "" {
+		t.Fatalf("commit with failed upload: %v %v %v", pos, ok, err)
+	}
+	if len(ep.objects) != 1 || len(standIn.keys()) != 0 {
+		t.Fatalf("buffered objects %d, uploaded %v", len(ep.objects), standIn.keys())
+	}
+
+	standIn.setFail(false)
+	pos, ok, err := ep.Commit(second)
+	if err != nil || !ok || pos.Compare(second) != 0 {
+		t.Fatalf("commit after upload: %v %v %v", pos, ok, err)
+	}
+	keys := standIn.keys()
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "shop/t_user/dt=") || !strings.HasSuffix(keys[0], ".jsonl") {
+		t.Fatalf("unexpected objects: %v", keys)
+	}
+	if body := standIn.objects[keys[0]]; !strings.Contains(body, `"jerry"`) {
+		t.Errorf("unexpected object body: %s", body)
+	}
+	if len(ep.objects) != 0 {
+		t.Errorf("%d objects left after upload", len(ep.objects))
+	}
+}
+
+func TestS3StockUploadOnClose(t *testing.T) {
+	ep, standIn := testS3Endpoint(t)
+
+	rows := []*model.RowRequest{testUpdateRequest(), testUpdateRequest()}
+	if n := ep.Stock(rows); n != 2 {
+		t.Fatalf("stock %d rows", n)
+	}
+	if keys := standIn.keys(); len(keys) != 0 {
+		t.Fatalf("uploaded before close: %v", keys)
+	}
+
+	ep.Close()
+	keys := standIn.keys()
+	if len(keys) != 1 {
+		t.Fatalf("unexpected objects after close: %v", keys)
+	}
+	if lines := strings.Count(standIn.objects[keys[0]], "\n"); lines != 2 {
+		t.Errorf("object has %d rows, want 2", lines)
+	}
+}
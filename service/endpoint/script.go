@@ -55,7 +55,10 @@ func (s *ScriptEndpoint) Consume(from mysql.Position, rows []*model.RowRequest)
 
 		metrics.UpdateActionNum(row.Action, row.RuleKey)
 		kvm := rowMap(row, rule, true)
+		span := luaSpan(row)
 		err := luaengine.DoScript(kvm, row.Action, rule)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			log.Println("Lua 脚本执行失败!!! ,详情请参见日志")
 			return errors.Errorf("Lua 脚本执行失败 : %s ", errors.ErrorStack(err))
@@ -77,7 +80,10 @@ func (s *ScriptEndpoint) Stock(rows []*model.RowRequest) int64 {
 		}
 
 		kvm := rowMap(row, rule, true)
+		span := luaSpan(row)
 		err := luaengine.DoScript(kvm, row.Action, rule)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			logs.Errorf("lua 脚本执行失败 : %s ", errors.ErrorStack(err))
 			break
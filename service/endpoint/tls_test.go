@@ -0,0 +1,187 @@
+package endpoint
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/proto/transport"
+)
+
+// useTargetTLS 以测试服务端的证书作为CA启用target_tls
+func useTargetTLS(t *testing.T, srv *httptest.Server, c *global.Config) {
+	dir, err := ioutil.TempDir("", "transfer-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ca := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c.TargetTLS = &global.TLSConfig{Enable: true, Ca: ca}
+	if err := c.BuildTLSConfigs(); err != nil {
+		t.Fatal(err)
+	}
+	global.ReplaceConfig(c)
+}
+
+func TestHttpEndpointTLS(t *testing.T) {
+	var received int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+	}))
+	defer srv.Close()
+
+	// 未配置target_tls时无法校验测试服务端的自签名证书
+	global.ReplaceConfig(&global.Config{HttpUrl: srv.URL, HttpTimeout: 5})
+	plain := newHttpEndpoint()
+	if err := plain.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.send(testUpdateRequest(), testMessageRule()); err == nil {
+		t.Fatal("expected certificate error without target_tls")
+	}
+
+	useTargetTLS(t, srv, &global.Config{HttpUrl: srv.URL, HttpTimeout: 5})
+	s := newHttpEndpoint()
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.send(testUpdateRequest(), testMessageRule()); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&received) != 1 {
+		t.Errorf("want 1 request, got %d", received)
+	}
+}
+
+func TestElastic7EndpointTLS(t *testing.T) {
+	var sniffed int32
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_nodes/http":
+			atomic.AddInt32(&sniffed, 1)
+			fmt.Fprintf(w, `{"nodes":{"n1":{"name":"n1","http":{"publish_address":"%s"}}}}`, srv.Listener.Addr())
+		case r.Method == http.MethodHead:
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer srv.Close()
+
+	// elsHosts根据target_tls补全https协议
+	addr := strings.TrimPrefix(srv.URL, "https://")
+	useTargetTLS(t, srv, &global.Config{ElsAddr: addr})
+	s := newElastic7Endpoint()
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if atomic.LoadInt32(&sniffed) == 0 {
+		t.Error("expected sniffing through tls")
+	}
+}
+
+type tlsTransportServer struct {
+	transport.UnimplementedTransportServer
+}
+
+func (s *tlsTransportServer) Ping(_ context.Context, in *transport.PingRequest) (*transport.PongResponse, error) {
+	return &transport.PongResponse{Context: in.Token}, nil
+}
+
+func TestGrpcEndpointTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	srv.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&srv.TLS.Certificates[0])))
+	transport.RegisterTransportServer(server, &tlsTransportServer{})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	global.ReplaceConfig(&global.Config{GrpcAddr: lis.Addr().String()})
+	plain := newGrpcEndpoint()
+	if err := plain.Connect(); err == nil {
+		t.Fatal("expected handshake error without target_tls")
+	}
+	plain.Close()
+
+	useTargetTLS(t, srv, &global.Config{GrpcAddr: lis.Addr().String()})
+	s := newGrpcEndpoint()
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
+
+func TestRedisEndpointTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	srv.Close()
+
+	// 只应答PING的redis服务端，握手失败的连接直接关闭
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	var pings int32
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.EqualFold(strings.TrimSpace(line), "ping") {
+						atomic.AddInt32(&pings, 1)
+						conn.Write([]byte("+PONG\r\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	useTargetTLS(t, srv, &global.Config{RedisAddr: lis.Addr().String()})
+	s := newRedisEndpoint()
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if atomic.LoadInt32(&pings) != 1 {
+		t.Errorf("want 1 ping, got %d", pings)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package endpoint
+
+import (
+	"reflect"
+
+	"github.com/juju/errors"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+)
+
+// Verifier 可选接口，由可按数据标识读取的接收端(Elasticsearch、MongoDB、Redis)实现，
+// 用于校验MySQL与接收端的数据是否一致(-verify)；不支持使用Lua脚本的规则
+type Verifier interface {
+	// Fetch 按规则转换rows，并读取各行在接收端对应的数据，返回结果与rows一一对应
+	Fetch(rule *global.Rule, rows []*model.RowRequest) ([]*VerifyRecord, error)
+	// Keys 分批遍历接收端中属于规则的全部数据标识(同VerifyRecord.Key)，用于发现MySQL中已不存在的数据；
+	// 无法确定数据是否属于该规则时(如多个规则写入同一索引)返回NotSupported错误(errors.IsNotSupported)
+	Keys(rule *global.Rule, fn func(keys []string) error) error
+}
+
+// VerifyRecord 一行数据在接收端的标识、按规则转换后的期望值及接收端中的实际值，Actual为nil表示接收端不存在该数据
+type VerifyRecord struct {
+	Key      string
+	Expected interface{}
+	Actual   interface{}
+}
+
+// Equal 期望值与实际值是否一致，JSON字符串按解析后的内容比较，数值不区分类型
+func (r *VerifyRecord) Equal() bool {
+	if r.Actual == nil {
+		return false
+	}
+	return reflect.DeepEqual(normalizeValue(r.Expected), normalizeValue(r.Actual))
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case string:
+		var parsed interface{}
+		if err := json.UnmarshalFromString(vv, &parsed); err == nil {
+			switch parsed.(type) {
+			case map[string]interface{}, []interface{}:
+				return parsed
+			}
+		}
+		return vv
+	case []byte:
+		return normalizeValue(string(vv))
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var parsed interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return v
+	}
+	return parsed
+}
+
+// sharedTarget 是否有其他规则写入同一位置，此时无法判断接收端中的数据属于哪个规则
+func sharedTarget(rule *global.Rule, same func(other *global.Rule) bool) bool {
+	for _, other := range global.RuleInsList() {
+		if other != rule && same(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// notSupportedf 无法完成的校验，各接收端统一返回juju的NotSupported错误
+func notSupportedf(format string, args ...interface{}) error {
+	return errors.NotSupportedf(format, args...)
+}
@@ -0,0 +1,21 @@
+package endpoint
+
+import "testing"
+
+func TestVerifyRecordEqual(t *testing.T) {
+	cases := []struct {
+		record *VerifyRecord
+		equal  bool
+	}{
+		{&VerifyRecord{Key: "1", Expected: map[string]interface{}{"id": 1, "name": "a"}, Actual: map[string]interface{}{"id": float64(1), "name": "a"}}, true},
+		{&VerifyRecord{Key: "2", Expected: map[string]interface{}{"id": 2}, Actual: `{"id":2}`}, true},
+		{&VerifyRecord{Key: "3", Expected: map[string]interface{}{"id": 3}, Actual: []byte(`{"id":4}`)}, false},
+		{&VerifyRecord{Key: "4", Expected: "abc", Actual: "abc"}, true},
+		{&VerifyRecord{Key: "5", Expected: "abc"}, false},
+	}
+	for _, c := range cases {
+		if got := c.record.Equal(); got != c.equal {
+			t.Errorf("key %s: Equal() = %v, want %v", c.record.Key, got, c.equal)
+		}
+	}
+}
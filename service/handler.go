@@ -18,6 +18,7 @@
 package service
 
 import (
+	"fmt"
 	"go-mysql-transfer/metrics"
 	"log"
 	"time"
@@ -26,25 +27,47 @@ import (
 	"github.com/siddontang/go-mysql/canal"
 	"github.com/siddontang/go-mysql/mysql"
 	"github.com/siddontang/go-mysql/replication"
+	"go.uber.org/atomic"
 
 	"go-mysql-transfer/global"
 	"go-mysql-transfer/model"
+	"go-mysql-transfer/service/endpoint"
+	"go-mysql-transfer/storage"
+	"go-mysql-transfer/tracing"
 	"go-mysql-transfer/util/logs"
 )
 
+// _maxBatchLinks 批次span最多关联的binlog事件数
+const _maxBatchLinks = 128
+
+// errPaused 暂停时在事务边界返回，使canal停止读取binlog
+var errPaused = errors.New("transfer paused")
+
 type handler struct {
-	queue chan interface{}
-	stop  chan struct{}
+	canal   *canal.Canal
+	queue   chan interface{}
+	stop    chan struct{}
+	drain   chan struct{}
+	done    chan struct{} // 监听协程退出后关闭
+	pausing atomic.Bool   // 正在暂停，canal在下一个事务边界停止
+	inTx    atomic.Bool   // 已读取当前事务的数据，尚未读取到事务结束
+	beatTx  bool          // 当前事务包含本节点写入的心跳，在canal的同步协程中更新
+	logName string        // 当前binlog文件名，在canal的同步协程中更新
+	gtid    string        // 当前事务的GTID，在canal的同步协程中更新
 }
 
-func newHandler() *handler {
+func newHandler(c *canal.Canal) *handler {
 	return &handler{
+		canal: c,
 		queue: make(chan interface{}, 4096),
 		stop:  make(chan struct{}, 1),
+		drain: make(chan struct{}, 1),
+		done:  make(chan struct{}),
 	}
 }
 
 func (s *handler) OnRotate(e *replication.RotateEvent) error {
+	s.logName = string(e.NextLogName)
 	s.queue <- model.PosRequest{
 		Name:  string(e.NextLogName),
 		Pos:   uint32(e.Position),
@@ -67,23 +90,59 @@ func (s *handler) OnDDL(nextPos mysql.Position, _ *replication.QueryEvent) error
 		Pos:   nextPos.Pos,
 		Force: true,
 	}
-	return nil
+	return s.txBoundary()
 }
 
 func (s *handler) OnXID(nextPos mysql.Position) error {
+	// 心跳事务结束时保存位置，MySQL空闲时binlog位置也能推进
 	s.queue <- model.PosRequest{
 		Name:  nextPos.Name,
 		Pos:   nextPos.Pos,
-		Force: false,
+		Force: s.beatTx,
+	}
+	return s.txBoundary()
+}
+
+// txBoundary 事务结束，正在暂停时停止读取
+func (s *handler) txBoundary() error {
+	s.inTx.Store(false)
+	s.beatTx = false
+	if s.pausing.Load() {
+		return errPaused
 	}
 	return nil
 }
 
 func (s *handler) OnRow(e *canal.RowsEvent) error {
 	ruleKey := global.RuleKey(e.Table.Schema, e.Table.Name)
-	if !global.RuleInsExist(ruleKey) {
+	beat := isHeartbeat(e)
+	if !beat && !global.RuleInsExist(ruleKey) {
 		return nil
 	}
+	// 正在暂停时不再读取新的事务
+	if s.pausing.Load() && !s.inTx.Load() {
+		return errPaused
+	}
+	s.inTx.Store(true)
+
+	if beat {
+		if req, ok := heartbeatRequest(e); ok {
+			s.beatTx = true
+			s.queue <- req
+		}
+		// 未给心跳表配置规则时不同步到接收端
+		if !global.RuleInsExist(ruleKey) {
+			return nil
+		}
+	}
+
+	span := tracing.Start("binlog.event", tracing.KindConsumer, tracing.SpanContext{})
+	defer span.End()
+	span.SetAttribute("db.table", ruleKey)
+	span.SetAttribute("action", e.Action)
+	span.SetAttribute("binlog.file", s.logName)
+	span.SetAttribute("binlog.pos", e.Header.LogPos)
+	span.SetAttribute("rows", len(e.Rows))
 
 	var requests []*model.RowRequest
 	if e.Action != canal.UpdateAction {
@@ -98,6 +157,12 @@ func (s *handler) OnRow(e *canal.RowsEvent) error {
 				v.RuleKey = ruleKey
 				v.Action = e.Action
 				v.Timestamp = e.Header.Timestamp
+				v.LogName = s.logName
+				v.LogPos = e.Header.LogPos
+				v.ServerId = e.Header.ServerID
+				v.Gtid = s.gtid
+				v.RowIndex = i / 2
+				v.Trace = span.Context()
 				if global.Cfg().IsReserveRawData() {
 					v.Old = e.Rows[i-1]
 				}
@@ -106,12 +171,18 @@ func (s *handler) OnRow(e *canal.RowsEvent) error {
 			}
 		}
 	} else {
-		for _, row := range e.Rows {
+		for i, row := range e.Rows {
 			v := new(model.RowRequest)
 			v.RuleKey = ruleKey
 			v.Action = e.Action
 			v.Timestamp = e.Header.Timestamp
+			v.LogName = s.logName
+			v.LogPos = e.Header.LogPos
+			v.ServerId = e.Header.ServerID
+			v.Gtid = s.gtid
+			v.RowIndex = i
 			v.Row = row
+			v.Trace = span.Context()
 			requests = append(requests, v)
 		}
 	}
@@ -121,6 +192,7 @@ func (s *handler) OnRow(e *canal.RowsEvent) error {
 }
 
 func (s *handler) OnGTID(gtid mysql.GTIDSet) error {
+	s.gtid = gtid.String()
 	return nil
 }
 
@@ -134,6 +206,7 @@ func (s *handler) String() string {
 
 func (s *handler) startListener() {
 	go func() {
+		defer close(s.done)
 		interval := time.Duration(global.Cfg().FlushBulkInterval)
 		bulkSize := global.Cfg().BulkSize
 		ticker := time.NewTicker(time.Millisecond * interval)
@@ -141,15 +214,22 @@ func (s *handler) startListener() {
 
 		lastSavedTime := time.Now()
 		requests := make([]*model.RowRequest, 0, bulkSize)
-		var current mysql.Position
+		var current, latest mysql.Position
+		var batchStart time.Time // 当前批次第一条数据的读取时间
+		var beat, lastBeat int64 // 待确认及已确认写入接收端的心跳时间(毫秒)
 		from, _ := _transferService.positionDao.Get()
 		for {
 			needFlush := false
 			needSavePos := false
+			exit := false
 			select {
 			case v := <-s.queue:
 				switch v := v.(type) {
 				case model.PosRequest:
+					latest = mysql.Position{
+						Name: v.Name,
+						Pos:  v.Pos,
+					}
 					now := time.Now()
 					if v.Force || now.Sub(lastSavedTime) > 3*time.Second {
 						lastSavedTime = now
@@ -161,17 +241,68 @@ func (s *handler) startListener() {
 						}
 					}
 				case []*model.RowRequest:
+					if len(requests) == 0 {
+						batchStart = time.Now()
+					}
 					requests = append(requests, v...)
 					needFlush = int64(len(requests)) >= global.Cfg().BulkSize
+				case model.HeartbeatRequest:
+					// 心跳之前读取的数据全部写入接收端后，心跳才算到达
+					beat = v.Timestamp
+					needFlush = true
 				}
 			case <-ticker.C:
 				needFlush = true
+				metrics.SetQueueDepth(len(s.queue))
+				if global.Cfg().IsHeartbeat() {
+					updateHeartbeatDelay(lastBeat)
+				} else if len(requests) == 0 && len(s.queue) == 0 {
+					s.updateIdleDelay()
+				}
 			case <-s.stop:
 				return
+			case <-s.drain:
+				// canal已停止，队列中不会再有新的数据：提交剩余数据并保存最后读取到的位置
+				for drained := false; !drained; {
+					select {
+					case v := <-s.queue:
+						switch v := v.(type) {
+						case model.PosRequest:
+							latest = mysql.Position{
+								Name: v.Name,
+								Pos:  v.Pos,
+							}
+						case []*model.RowRequest:
+							if len(requests) == 0 {
+								batchStart = time.Now()
+							}
+							requests = append(requests, v...)
+						}
+					default:
+						drained = true
+					}
+				}
+				exit = true
+				needFlush = true
+				needSavePos = latest.Name != "" && latest.Compare(from) != 0
+				current = latest
 			}
 
 			if needFlush && len(requests) > 0 && _transferService.endpointEnable.Load() {
+				batch := tracing.StartAt("transfer.batch", tracing.KindInternal, tracing.SpanContext{}, batchStart)
+				batch.SetAttribute("rows", len(requests))
+				linkEvents(batch, requests)
+				consume := tracing.Start("endpoint.consume", tracing.KindProducer, batch.Context())
+				consume.SetAttribute("target", global.Cfg().Target)
+				begin := time.Now()
 				err := _transferService.endpoint.Consume(from, requests)
+				consume.SetError(err)
+				consume.End()
+				batch.SetError(err)
+				batch.End()
+				if err == nil {
+					observeConsume(requests, begin)
+				}
 				if err != nil {
 					_transferService.endpointEnable.Store(false)
 					metrics.SetDestState(metrics.DestStateFail)
@@ -180,20 +311,158 @@ func (s *handler) startListener() {
 				}
 				requests = requests[0:0]
 			}
+			if beat > 0 && len(requests) == 0 && _transferService.endpointEnable.Load() {
+				lastBeat, beat = beat, 0
+				updateHeartbeatDelay(lastBeat)
+			}
 			if needSavePos && _transferService.endpointEnable.Load() {
+				if committer, ok := _transferService.endpoint.(endpoint.Committer); ok {
+					committed, ok, err := committer.Commit(current)
+					if err != nil {
+						_transferService.endpointEnable.Store(false)
+						metrics.SetDestState(metrics.DestStateFail)
+						logs.Error(err.Error())
+						if exit {
+							return
+						}
+						go _transferService.stopDump()
+						continue
+					}
+					if !ok {
+						if exit {
+							return
+						}
+						continue
+					}
+					current = committed
+				}
 				logs.Infof("save position %s %d", current.Name, current.Pos)
-				if err := _transferService.positionDao.Save(current); err != nil {
+				span := tracing.Start("position.save", tracing.KindInternal, tracing.SpanContext{})
+				span.SetAttribute("binlog.file", current.Name)
+				span.SetAttribute("binlog.pos", current.Pos)
+				err := _transferService.positionDao.Save(current)
+				span.SetError(err)
+				span.End()
+				if storage.IsFenced(err) { // 已失去leader身份，立即停止同步
+					log.Println(fmt.Sprintf("save sync position %s rejected: %v, stop dump", current, err))
+					logs.Errorf("save sync position %s rejected: %v, stop dump", current, err)
+					go _transferService.stopDump()
+					return
+				}
+				if err != nil {
 					logs.Errorf("save sync position %s err %v, close sync", current, err)
-					_transferService.Close()
+					go _transferService.Close()
 					return
 				}
 				from = current
 			}
+			if exit {
+				log.Println(fmt.Sprintf("transfer drained at position(%s %d)", from.Name, from.Pos))
+				return
+			}
 		}
 	}()
 }
 
+// linkEvents 批次关联其包含的binlog事件，同一事件的多行只关联一次
+func linkEvents(batch *tracing.Span, requests []*model.RowRequest) {
+	if batch == nil {
+		return
+	}
+	var last tracing.SpanContext
+	linked := 0
+	for _, row := range requests {
+		if !row.Trace.IsValid() || row.Trace == last {
+			continue
+		}
+		last = row.Trace
+		batch.AddLink(row.Trace)
+		linked++
+		if linked >= _maxBatchLinks {
+			return
+		}
+	}
+}
+
+// observeConsume 根据binlog事件时间计算同步延迟及各表的端到端延迟
+func observeConsume(requests []*model.RowRequest, begin time.Time) {
+	now := time.Now()
+	metrics.ObserveConsume(len(requests), now.Sub(begin))
+
+	var latest uint32
+	for _, row := range requests {
+		if row.Timestamp == 0 {
+			continue
+		}
+		if row.Timestamp > latest {
+			latest = row.Timestamp
+		}
+		metrics.ObserveLatency(row.RuleKey, now.Sub(time.Unix(int64(row.Timestamp), 0)).Seconds())
+	}
+	// 开启心跳表时以心跳计算同步延迟
+	if latest > 0 && !global.Cfg().IsHeartbeat() {
+		metrics.SetTransferDelay(elapsed(latest, now))
+	}
+}
+
+// updateHeartbeatDelay 同步延迟为当前时间与最后一个写入接收端的心跳的时间差，精度为心跳间隔
+func updateHeartbeatDelay(lastBeat int64) {
+	if lastBeat == 0 {
+		return
+	}
+	d := time.Since(time.Unix(0, lastBeat*int64(time.Millisecond)))
+	if d < 0 {
+		d = 0
+	}
+	metrics.SetHeartbeatDelay(d)
+}
+
+// updateIdleDelay 没有待处理的数据时，以canal读取最后一个事件时的延迟作为同步延迟；
+// 心跳事件的时间为0，canal记录的延迟即为读取到心跳的时间：心跳间隔内读取到过心跳说明已追上MySQL，延迟为0，
+// 否则延迟不超过距最后一次心跳的时间
+func (s *handler) updateIdleDelay() {
+	if s.canal == nil || !_transferService.canalEnable.Load() {
+		return
+	}
+	d := s.canal.GetDelay()
+	since := elapsed(d, time.Now())
+	if d > since {
+		if since <= uint32(global.Cfg().HeartbeatPeriod) {
+			since = 0
+		}
+		d = since
+	}
+	metrics.SetTransferDelay(d)
+}
+
+func elapsed(timestamp uint32, now time.Time) uint32 {
+	n := uint32(now.Unix())
+	if n < timestamp {
+		return 0
+	}
+	return n - timestamp
+}
+
+// drainListener canal停止后调用：提交已读取的数据、保存binlog位置，并等待监听协程退出
+func (s *handler) drainListener() {
+	select {
+	case s.drain <- struct{}{}:
+	default:
+	}
+	<-s.done
+}
+
+// inTransaction 是否已读取当前事务的部分数据
+func (s *handler) inTransaction() bool {
+	return s.inTx.Load()
+}
+
+// stopListener 停止监听协程并等待其退出，已读取但未提交的数据被丢弃，恢复后从已保存的binlog位置重新读取
 func (s *handler) stopListener() {
 	log.Println("transfer stop")
-	s.stop <- struct{}{}
+	select {
+	case s.stop <- struct{}{}:
+	default:
+	}
+	<-s.done
 }
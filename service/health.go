@@ -0,0 +1,124 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/metrics"
+)
+
+const (
+	RoleStandalone = "standalone"
+	RoleLeader     = "leader"
+	RoleFollower   = "follower"
+	RoleShard      = "shard" // 按表分片的集群节点
+)
+
+// HealthStatus 健康检查结果，live为false时应重启进程，ready为false时不应视为正常提供同步服务
+type HealthStatus struct {
+	Live           bool     `json:"live"`
+	Ready          bool     `json:"ready"`
+	Role           string   `json:"role"`
+	CanalEnable    bool     `json:"canalEnable"`
+	EndpointEnable bool     `json:"endpointEnable"`
+	Paused         bool     `json:"paused"`
+	Delay          uint32   `json:"delay"`
+	Tables         int      `json:"tables,omitempty"` // 按表分片时本节点负责的表数
+	StorageEnable  bool     `json:"storageEnable"`
+	Reasons        []string `json:"reasons,omitempty"`
+}
+
+// canal意外停止持续超过该时间(秒)且没有正在进行的停止、重启时才判定为不存活，
+// 期间的检查只影响就绪，避免切换过程中或短暂异常时被重启
+const _canalStoppedLiveTimeout = 60
+
+// CheckHealth 检查同步状态：
+// 接收端正常、同步本应运行却持续停止(如canal异常退出)超过_canalStoppedLiveTimeout秒时为不存活；
+// 存储(bolt/zk/etcd)不可访问、同步暂停或停止、接收端不可用、延迟超过ready_max_delay时为未就绪，
+// follower节点及未分配到表的分片节点作为备用节点不检查同步状态
+func CheckHealth() *HealthStatus {
+	return checkHealth(_transferService, time.Now())
+}
+
+func checkHealth(s *TransferService, now time.Time) *HealthStatus {
+	h := &HealthStatus{
+		Live:           true,
+		Ready:          true,
+		Role:           RoleStandalone,
+		CanalEnable:    s.CanalEnable(),
+		EndpointEnable: s.EndpointEnable(),
+		Paused:         s.Paused(),
+		Delay:          metrics.TransferDelay(),
+		StorageEnable:  true,
+	}
+	if global.Cfg().IsCluster() {
+		h.Role = RoleFollower
+		if global.IsLeader() {
+			h.Role = RoleLeader
+		}
+	}
+	if global.Cfg().IsSharding() {
+		h.Role = RoleShard
+		h.Tables = len(s.ShardTables())
+	}
+
+	if _, err := s.Position(); err != nil {
+		h.StorageEnable = false
+		h.Ready = false
+		h.Reasons = append(h.Reasons, fmt.Sprintf("storage not available: %s", err.Error()))
+	}
+
+	if h.Role == RoleFollower || (h.Role == RoleShard && h.Tables == 0) {
+		s.stoppedAt.Store(0)
+		return h
+	}
+
+	if !h.Paused && h.EndpointEnable && !h.CanalEnable && !s.Switching() {
+		h.Ready = false
+		stoppedAt := s.stoppedAt.Load()
+		if stoppedAt == 0 {
+			stoppedAt = now.Unix()
+			s.stoppedAt.Store(stoppedAt)
+		}
+		if now.Unix()-stoppedAt >= _canalStoppedLiveTimeout {
+			h.Live = false
+			h.Reasons = append(h.Reasons, fmt.Sprintf("canal stopped unexpectedly for %ds", now.Unix()-stoppedAt))
+		} else {
+			h.Reasons = append(h.Reasons, "canal stopped unexpectedly")
+		}
+	} else {
+		s.stoppedAt.Store(0)
+	}
+
+	if h.Paused {
+		h.Ready = false
+		h.Reasons = append(h.Reasons, "transfer paused")
+	}
+	if !h.EndpointEnable {
+		h.Ready = false
+		h.Reasons = append(h.Reasons, "destination not available")
+	}
+	if max := global.Cfg().ReadyMaxDelay; max > 0 && h.Delay > uint32(max) {
+		h.Ready = false
+		h.Reasons = append(h.Reasons, fmt.Sprintf("delay %ds exceeds %ds", h.Delay, max))
+	}
+	return h
+}
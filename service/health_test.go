@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/siddontang/go-mysql/mysql"
+
+	"go-mysql-transfer/global"
+)
+
+type fakePositionStorage struct {
+	err error
+}
+
+func (s *fakePositionStorage) Initialize() error { return nil }
+
+func (s *fakePositionStorage) Save(mysql.Position) error { return s.err }
+
+func (s *fakePositionStorage) Get() (mysql.Position, error) { return mysql.Position{}, s.err }
+
+func TestCheckHealthCanalStopped(t *testing.T) {
+	global.ReplaceConfig(&global.Config{})
+	s := &TransferService{positionDao: &fakePositionStorage{}}
+	s.endpointEnable.Store(true)
+	now := time.Now()
+
+	// 刚发现停止时只影响就绪
+	h := checkHealth(s, now)
+	if !h.Live || h.Ready {
+		t.Fatalf("just stopped: live=%v ready=%v", h.Live, h.Ready)
+	}
+
+	// 停止、重启进行中不计入停止时间
+	s.switching.Inc()
+	h = checkHealth(s, now.Add(_canalStoppedLiveTimeout*time.Second))
+	if !h.Live {
+		t.Fatal("not live while switching")
+	}
+	s.switching.Dec()
+
+	h = checkHealth(s, now.Add(_canalStoppedLiveTimeout*time.Second))
+	if !h.Live {
+		t.Fatal("stopped time should restart after switching")
+	}
+	h = checkHealth(s, now.Add(2*_canalStoppedLiveTimeout*time.Second))
+	if h.Live || h.Ready {
+		t.Fatalf("stopped too long: live=%v ready=%v", h.Live, h.Ready)
+	}
+
+	// 恢复运行后重新计时
+	s.canalEnable.Store(true)
+	if h = checkHealth(s, now.Add(3*_canalStoppedLiveTimeout*time.Second)); !h.Live || !h.Ready {
+		t.Fatalf("running: live=%v ready=%v", h.Live, h.Ready)
+	}
+	if s.stoppedAt.Load() != 0 {
+		t.Error("stopped time not reset")
+	}
+}
+
+func TestCheckHealthStorageUnavailable(t *testing.T) {
+	global.ReplaceConfig(&global.Config{})
+	s := &TransferService{positionDao: &fakePositionStorage{err: errors.New("bolt closed")}}
+	s.endpointEnable.Store(true)
+	s.canalEnable.Store(true)
+
+	h := checkHealth(s, time.Now())
+	if !h.Live || h.Ready || h.StorageEnable {
+		t.Fatalf("storage down: live=%v ready=%v storage=%v", h.Live, h.Ready, h.StorageEnable)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/client"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/util/logs"
+	"go-mysql-transfer/util/stringutil"
+)
+
+// heartbeat 定期更新心跳表中以slave_id为主键的一行，同步协程从binlog中读取到后计算端到端延迟；
+// 心跳事务产生的XID事件使MySQL空闲时binlog位置也能推进。为心跳表配置规则时，心跳与普通数据一样同步到接收端
+type heartbeat struct {
+	schema string
+	table  string
+	id     string
+	conn   *client.Conn
+	stop   chan struct{}
+}
+
+func newHeartbeat() *heartbeat {
+	schema, table := global.Cfg().HeartbeatSchemaTable()
+	return &heartbeat{
+		schema: schema,
+		table:  table,
+		id:     strconv.FormatUint(uint64(global.Cfg().SlaveID), 10),
+		stop:   make(chan struct{}),
+	}
+}
+
+// initialize 心跳表不存在时创建
+func (h *heartbeat) initialize() error {
+	if err := h.connect(); err != nil {
+		return errors.Trace(err)
+	}
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`.`%s` (`id` VARCHAR(64) NOT NULL, `ts` BIGINT NOT NULL, PRIMARY KEY (`id`))", h.schema, h.table)
+	if _, err := h.conn.Execute(sql); err != nil {
+		return errors.Annotatef(err, "create heartbeat table %s.%s", h.schema, h.table)
+	}
+	return nil
+}
+
+func (h *heartbeat) connect() error {
+	if h.conn != nil {
+		h.conn.Close()
+		h.conn = nil
+	}
+	c := global.Cfg()
+	conn, err := client.Connect(c.Addr, c.User, c.Password, "", func(conn *client.Conn) {
+		if c.MysqlTLSConfig() != nil {
+			conn.SetTLSConfig(c.MysqlTLSConfig())
+		}
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	h.conn = conn
+	return nil
+}
+
+// start 同步运行时(单机或leader节点)定期写入心跳
+func (h *heartbeat) start() {
+	go func() {
+		ticker := time.NewTicker(time.Duration(global.Cfg().HeartbeatInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !_transferService.canalEnable.Load() {
+					continue
+				}
+				if err := h.write(); err != nil {
+					logs.Errorf("write heartbeat: %s", err.Error())
+				}
+			case <-h.stop:
+				if h.conn != nil {
+					h.conn.Close()
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (h *heartbeat) write() error {
+	if h.conn == nil {
+		if err := h.connect(); err != nil {
+			return err
+		}
+	}
+	sql := fmt.Sprintf("INSERT INTO `%s`.`%s` (`id`, `ts`) VALUES ('%s', %d) ON DUPLICATE KEY UPDATE `ts` = VALUES(`ts`)",
+		h.schema, h.table, h.id, time.Now().UnixNano()/int64(time.Millisecond))
+	if _, err := h.conn.Execute(sql); err != nil {
+		// 连接断开后下次重新连接
+		h.conn.Close()
+		h.conn = nil
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func (h *heartbeat) close() {
+	close(h.stop)
+}
+
+// isHeartbeat binlog事件是否为心跳表的事件
+func isHeartbeat(e *canal.RowsEvent) bool {
+	if !global.Cfg().IsHeartbeat() {
+		return false
+	}
+	schema, table := global.Cfg().HeartbeatSchemaTable()
+	return e.Table.Schema == schema && e.Table.Name == table
+}
+
+// heartbeatRequest 从心跳表的事件中取出本节点写入的心跳，没有时返回false
+func heartbeatRequest(e *canal.RowsEvent) (model.HeartbeatRequest, bool) {
+	var req model.HeartbeatRequest
+	idIndex := e.Table.FindColumn("id")
+	tsIndex := e.Table.FindColumn("ts")
+	if idIndex < 0 || tsIndex < 0 || e.Action == canal.DeleteAction {
+		return req, false
+	}
+	id := strconv.FormatUint(uint64(global.Cfg().SlaveID), 10)
+	for i, row := range e.Rows {
+		// 更新事件中奇数行为更新后的数据
+		if e.Action == canal.UpdateAction && i%2 == 0 {
+			continue
+		}
+		if len(row) <= idIndex || len(row) <= tsIndex || stringutil.ToString(row[idIndex]) != id {
+			continue
+		}
+		req.Timestamp = stringutil.ToInt64Safe(stringutil.ToString(row[tsIndex]))
+		return req, req.Timestamp > 0
+	}
+	return req, false
+}
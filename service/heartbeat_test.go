@@ -0,0 +1,95 @@
+package service
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/server"
+
+	"go-mysql-transfer/global"
+)
+
+func TestHeartbeatConnectTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	srv.Close()
+
+	dir, err := ioutil.TempDir("", "transfer-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 记录TLS握手次数的MySQL服务端
+	var handshakes int32
+	serverTLS := &tls.Config{
+		Certificates: srv.TLS.Certificates,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			atomic.AddInt32(&handshakes, 1)
+			return nil, nil
+		},
+	}
+	mysqlServer := server.NewServer("5.7.0", mysql.DEFAULT_COLLATION_ID, mysql.AUTH_NATIVE_PASSWORD, nil, serverTLS)
+	provider := server.NewInMemoryProvider()
+	provider.AddUser("root", "secret")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				conn, err := server.NewCustomizedConn(c, mysqlServer, provider, server.EmptyHandler{})
+				if err != nil {
+					c.Close()
+					return
+				}
+				for !conn.Closed() {
+					if err := conn.HandleCommand(); err != nil {
+						return
+					}
+				}
+			}(c)
+		}
+	}()
+
+	// ServerName由连接地址补全，与测试证书中的127.0.0.1一致
+	c := &global.Config{
+		Addr:     lis.Addr().String(),
+		User:     "root",
+		Password: "secret",
+		MysqlTLS: &global.TLSConfig{Enable: true, Ca: ca},
+	}
+	if err := c.BuildTLSConfigs(); err != nil {
+		t.Fatal(err)
+	}
+	global.ReplaceConfig(c)
+
+	h := &heartbeat{}
+	if err := h.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.conn.Close()
+	if atomic.LoadInt32(&handshakes) != 1 {
+		t.Errorf("want 1 tls handshake, got %d", handshakes)
+	}
+}
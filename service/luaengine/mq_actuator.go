@@ -24,6 +24,8 @@ import (
 	"go-mysql-transfer/model"
 )
 
+const _globalHDR = "___HDR___"
+
 func mqModule(L *lua.LState) int {
 	t := L.NewTable()
 	L.SetFuncs(t, _mqModuleApi)
@@ -38,12 +40,18 @@ var _mqModuleApi = map[string]lua.LGFunction{
 	"SEND": msgSend,
 }
 
+// msgSend SEND(topic, msg, headers)，第三个参数为可选的消息头table
 func msgSend(L *lua.LState) int {
 	topic := L.CheckAny(1)
 	msg := L.CheckAny(2)
+	headers := L.OptTable(3, nil)
 
 	ret := L.GetGlobal(_globalRET)
 	L.SetTable(ret, msg, topic)
+	if headers != nil {
+		hdr := L.GetGlobal(_globalHDR)
+		L.SetTable(hdr, msg, headers)
+	}
 	return 0
 }
 
@@ -55,6 +63,8 @@ func DoMQOps(input map[string]interface{}, action string, rule *global.Rule) ([]
 	paddingTable(L, row, input)
 	ret := L.NewTable()
 	L.SetGlobal(_globalRET, ret)
+	hdr := L.NewTable()
+	L.SetGlobal(_globalHDR, hdr)
 	L.SetGlobal(_globalROW, row)
 	L.SetGlobal(_globalACT, lua.LString(action))
 
@@ -70,6 +80,12 @@ func DoMQOps(input map[string]interface{}, action string, rule *global.Rule) ([]
 		resp := new(model.MQRespond)
 		resp.ByteArray = lvToByteArray(k)
 		resp.Topic = lvToString(v)
+		if headers, ok := hdr.RawGet(k).(*lua.LTable); ok {
+			resp.Headers = make(map[string]string)
+			headers.ForEach(func(hk lua.LValue, hv lua.LValue) {
+				resp.Headers[lvToString(hk)] = lvToString(hv)
+			})
+		}
 		list = append(list, resp)
 	})
 
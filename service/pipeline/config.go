@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package pipeline
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	_defaultRestartInterval = 5
+	_defaultStopTimeout     = 30
+
+	_defaultWebAdminPort = 8060
+	_defaultExporterPort = 9595
+)
+
+// Config 管道配置文件，每个管道对应一个独立的transfer配置文件(数据源、接收端及规则)
+type Config struct {
+	EnableWebAdmin  bool      `yaml:"enable_web_admin"` // 启用管道管理接口，默认false
+	WebAdminPort    int       `yaml:"web_admin_port"`   // 管道管理接口端口，默认8060
+	WebAdminToken   string    `yaml:"web_admin_token"`  // 管道管理接口的访问令牌，启用管理接口时不能为空
+	RestartInterval int       `yaml:"restart_interval"` // 管道异常退出后重启的间隔(秒)，默认5，小于0不重启
+	StopTimeout     int       `yaml:"stop_timeout"`     // 停止管道时等待其退出的时间(秒)，超时后强制结束，默认30
+	Pipelines       []*Option `yaml:"pipelines"`
+}
+
+// Option 单个管道的配置
+type Option struct {
+	Name    string `yaml:"name"`    // 管道名称，用于日志前缀、指标的pipeline标签及默认的数据目录
+	Config  string `yaml:"config"`  // transfer配置文件，相对路径基于管道配置文件所在目录
+	Disable bool   `yaml:"disable"` // 禁用，默认false
+}
+
+// 管道配置文件中与其他管道冲突的配置项
+type pipelineFileConfig struct {
+	DataDir        string `yaml:"data_dir"`
+	EnableWebAdmin bool   `yaml:"enable_web_admin"`
+	WebAdminPort   int    `yaml:"web_admin_port"`
+	EnableExporter bool   `yaml:"enable_exporter"`
+	ExporterPort   int    `yaml:"exporter_addr"`
+}
+
+func loadConfig(fileName string) (*Config, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := checkConfig(&c, filepath.Dir(fileName)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &c, nil
+}
+
+func checkConfig(c *Config, dir string) error {
+	if c.WebAdminPort == 0 {
+		c.WebAdminPort = _defaultWebAdminPort
+	}
+	if c.RestartInterval == 0 {
+		c.RestartInterval = _defaultRestartInterval
+	}
+	if c.StopTimeout <= 0 {
+		c.StopTimeout = _defaultStopTimeout
+	}
+	if c.EnableWebAdmin && c.WebAdminToken == "" {
+		return errors.Errorf("empty web_admin_token not allowed")
+	}
+	if len(c.Pipelines) == 0 {
+		return errors.Errorf("empty pipelines not allowed")
+	}
+
+	names := make(map[string]bool)
+	dataDirs := make(map[string]string)
+	ports := make(map[int]string)
+	if c.EnableWebAdmin {
+		ports[c.WebAdminPort] = "pipelines web_admin_port"
+	}
+
+	for _, option := range c.Pipelines {
+		if option.Name == "" {
+			return errors.Errorf("empty pipeline name not allowed")
+		}
+		if filepath.Base(option.Name) != option.Name {
+			return errors.Errorf("pipeline name %s must not contain path separators", option.Name)
+		}
+		if names[option.Name] {
+			return errors.Errorf("duplicate pipeline %s", option.Name)
+		}
+		names[option.Name] = true
+
+		if option.Config == "" {
+			return errors.Errorf("empty config of pipeline %s not allowed", option.Name)
+		}
+		if !filepath.IsAbs(option.Config) {
+			option.Config = filepath.Join(dir, option.Config)
+		}
+		data, err := ioutil.ReadFile(option.Config)
+		if err != nil {
+			return errors.Annotatef(err, "pipeline %s", option.Name)
+		}
+		if option.Disable {
+			continue
+		}
+
+		// 环境变量在子进程中替换，这里只检查明确配置的冲突项
+		var pc pipelineFileConfig
+		if err := yaml.Unmarshal(data, &pc); err != nil {
+			return errors.Annotatef(err, "pipeline %s", option.Name)
+		}
+		if pc.DataDir != "" {
+			if other, ok := dataDirs[pc.DataDir]; ok {
+				return errors.Errorf("pipeline %s and %s use the same data_dir %s", other, option.Name, pc.DataDir)
+			}
+			dataDirs[pc.DataDir] = option.Name
+		}
+		if pc.EnableWebAdmin {
+			if pc.WebAdminPort == 0 {
+				pc.WebAdminPort = _defaultWebAdminPort
+			}
+			if other, ok := ports[pc.WebAdminPort]; ok {
+				return errors.Errorf("web_admin_port %d of pipeline %s conflicts with %s", pc.WebAdminPort, option.Name, other)
+			}
+			ports[pc.WebAdminPort] = "pipeline " + option.Name
+		}
+		if pc.EnableExporter {
+			if pc.ExporterPort == 0 {
+				pc.ExporterPort = _defaultExporterPort
+			}
+			if other, ok := ports[pc.ExporterPort]; ok {
+				return errors.Errorf("exporter_addr %d of pipeline %s conflicts with %s", pc.ExporterPort, option.Name, other)
+			}
+			ports[pc.ExporterPort] = "pipeline " + option.Name
+		}
+	}
+
+	return nil
+}
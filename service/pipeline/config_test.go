@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.yml": "data_dir: /data/a\nenable_web_admin: true\n",
+		"b.yml": "data_dir: /data/b\nenable_web_admin: true\nweb_admin_port: 8061\n",
+		"c.yml": "data_dir: /data/a\n",
+		"d.yml": "enable_exporter: true\nexporter_addr: 8061\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name      string
+		pipelines []*Option
+		err       string
+	}{
+		{name: "ok", pipelines: []*Option{{Name: "a", Config: "a.yml"}, {Name: "b", Config: "b.yml"}}},
+		{name: "disabled conflict ignored", pipelines: []*Option{{Name: "a", Config: "a.yml"}, {Name: "c", Config: "c.yml", Disable: true}}},
+		{name: "duplicate name", pipelines: []*Option{{Name: "a", Config: "a.yml"}, {Name: "a", Config: "b.yml"}}, err: "duplicate pipeline"},
+		{name: "same data_dir", pipelines: []*Option{{Name: "a", Config: "a.yml"}, {Name: "c", Config: "c.yml"}}, err: "same data_dir"},
+		{name: "port conflict", pipelines: []*Option{{Name: "b", Config: "b.yml"}, {Name: "d", Config: "d.yml"}}, err: "conflicts with pipeline b"},
+		{name: "admin port conflict", pipelines: []*Option{{Name: "a", Config: "a.yml"}}, err: "conflicts with pipelines"},
+		{name: "missing file", pipelines: []*Option{{Name: "x", Config: "x.yml"}}, err: "no such file"},
+	}
+	for _, c := range cases {
+		cfg := &Config{Pipelines: c.pipelines, EnableWebAdmin: c.name == "admin port conflict", WebAdminToken: "token"}
+		err := checkConfig(cfg, dir)
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package pipeline
+
+import (
+	"log"
+	"sync"
+
+	"github.com/juju/errors"
+	"go.uber.org/atomic"
+)
+
+// Manager 在一个transfer进程中管理多个相互独立的管道，每个管道以子进程运行，
+// 拥有各自的数据源、接收端、规则、binlog位置及指标。
+// 配置(global.Cfg)、规则实例、接收端及同步服务都是进程级的单例，无法在同一进程内并存多份，
+// 因此以子进程隔离管道，管道由管道配置文件描述，未使用model.PipelineInfo、model.TargetInfo
+type Manager struct {
+	lock      sync.Mutex
+	fileName  string
+	secretKey string
+	cfg       *Config
+	processes map[string]*process
+	names     []string // 按配置文件中的顺序
+
+	restartInterval atomic.Int64 // 可热加载的restart_interval，子进程退出、停止时不持有lock读取
+	stopTimeout     atomic.Int64 // 可热加载的stop_timeout
+}
+
+// NewManager secretKey传递给子进程，用于解密配置中的enc:值
+func NewManager(fileName, secretKey string) (*Manager, error) {
+	cfg, err := loadConfig(fileName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	m := &Manager{
+		fileName:  fileName,
+		secretKey: secretKey,
+		cfg:       cfg,
+		processes: make(map[string]*process),
+	}
+	m.restartInterval.Store(int64(cfg.RestartInterval))
+	m.stopTimeout.Store(int64(cfg.StopTimeout))
+	for _, option := range cfg.Pipelines {
+		m.processes[option.Name] = newProcess(option, m)
+		m.names = append(m.names, option.Name)
+	}
+	return m, nil
+}
+
+// Config 启动时的管道配置，热加载后restart_interval、stop_timeout以Manager中的为准
+func (m *Manager) Config() *Config {
+	return m.cfg
+}
+
+// Start 启动所有未禁用的管道
+func (m *Manager) Start() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, name := range m.names {
+		p := m.processes[name]
+		if p.option.Disable {
+			continue
+		}
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) process(name string) (*process, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	p, ok := m.processes[name]
+	if !ok {
+		return nil, errors.NotFoundf("pipeline %s", name)
+	}
+	return p, nil
+}
+
+func (m *Manager) StartPipeline(name string) error {
+	p, err := m.process(name)
+	if err != nil {
+		return err
+	}
+	if p.option.Disable {
+		return errors.Errorf("pipeline %s is disabled", name)
+	}
+	return p.start()
+}
+
+func (m *Manager) StopPipeline(name string) error {
+	p, err := m.process(name)
+	if err != nil {
+		return err
+	}
+	p.stop()
+	return nil
+}
+
+func (m *Manager) RestartPipeline(name string) error {
+	if err := m.StopPipeline(name); err != nil {
+		return err
+	}
+	return m.StartPipeline(name)
+}
+
+func (m *Manager) Statuses() []*Status {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	statuses := make([]*Status, 0, len(m.names))
+	for _, name := range m.names {
+		statuses = append(statuses, m.processes[name].status())
+	}
+	return statuses
+}
+
+// Reload 重新读取管道配置文件：启动新增的管道，停止删除或禁用的管道，重启配置文件路径变化的管道
+func (m *Manager) Reload() error {
+	cfg, err := loadConfig(m.fileName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.restartInterval.Store(int64(cfg.RestartInterval))
+	m.stopTimeout.Store(int64(cfg.StopTimeout))
+
+	news := make(map[string]*Option, len(cfg.Pipelines))
+	for _, option := range cfg.Pipelines {
+		news[option.Name] = option
+	}
+	for _, name := range m.names {
+		if _, ok := news[name]; !ok {
+			m.processes[name].stop()
+			delete(m.processes, name)
+			log.Printf("pipeline %s removed \n", name)
+		}
+	}
+
+	names := make([]string, 0, len(cfg.Pipelines))
+	for _, option := range cfg.Pipelines {
+		names = append(names, option.Name)
+		p, ok := m.processes[option.Name]
+		if !ok {
+			p = newProcess(option, m)
+			m.processes[option.Name] = p
+			log.Printf("pipeline %s added \n", option.Name)
+			if !option.Disable {
+				if err := p.start(); err != nil {
+					log.Println(errors.ErrorStack(err))
+				}
+			}
+			continue
+		}
+
+		changed := p.option.Config != option.Config || p.option.Disable != option.Disable
+		if !changed {
+			continue
+		}
+		p.stop()
+		p.lock.Lock()
+		p.option = option
+		p.lock.Unlock()
+		if !option.Disable {
+			if err := p.start(); err != nil {
+				log.Println(errors.ErrorStack(err))
+			}
+		}
+	}
+	m.names = names
+
+	return nil
+}
+
+// Close 并行停止所有管道
+func (m *Manager) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range m.processes {
+		wg.Add(1)
+		go func(p *process) {
+			p.stop()
+			wg.Done()
+		}(p)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package pipeline
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/util/dates"
+)
+
+const (
+	StateStopped = "stopped"
+	StateRunning = "running"
+	StateExited  = "exited" // 异常退出，等待重启或已放弃重启
+)
+
+// Status 管道的运行状态
+type Status struct {
+	Name      string `json:"name"`
+	Config    string `json:"config"`
+	Disable   bool   `json:"disable"`
+	State     string `json:"state"`
+	Pid       int    `json:"pid"`
+	StartTime string `json:"startTime"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"lastError"`
+}
+
+// process 以子进程运行的管道：transfer -config {config} -pipeline {name}
+type process struct {
+	lock    sync.Mutex
+	option  *Option
+	manager *Manager
+
+	cmd       *exec.Cmd
+	state     string
+	startTime time.Time
+	restarts  int
+	lastError string
+	stopping  bool
+	done      chan struct{}
+}
+
+func newProcess(option *Option, manager *Manager) *process {
+	return &process{
+		option:  option,
+		manager: manager,
+		state:   StateStopped,
+	}
+}
+
+func (p *process) start() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.state == StateRunning {
+		return nil
+	}
+
+	cmd := exec.Command(os.Args[0], "-config", p.option.Config, "-pipeline", p.option.Name)
+	cmd.Env = os.Environ()
+	if p.manager.secretKey != "" {
+		cmd.Env = append(cmd.Env, global.SecretKeyEnv+"="+p.manager.secretKey)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := cmd.Start(); err != nil {
+		p.lastError = err.Error()
+		return errors.Annotatef(err, "start pipeline %s", p.option.Name)
+	}
+
+	prefix := "[" + p.option.Name + "] "
+	go copyWithPrefix(os.Stdout, stdout, prefix)
+	go copyWithPrefix(os.Stderr, stderr, prefix)
+
+	p.cmd = cmd
+	p.state = StateRunning
+	p.stopping = false
+	p.startTime = time.Now()
+	p.done = make(chan struct{})
+	log.Printf("pipeline %s started, pid: %d \n", p.option.Name, cmd.Process.Pid)
+
+	go p.wait(cmd, p.done)
+	return nil
+}
+
+func (p *process) wait(cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
+
+	p.lock.Lock()
+	stopping := p.stopping
+	if stopping {
+		p.state = StateStopped
+	} else {
+		p.state = StateExited
+		if err != nil {
+			p.lastError = err.Error()
+		} else {
+			p.lastError = "exited"
+		}
+	}
+	p.cmd = nil
+	name, lastError := p.option.Name, p.lastError
+	p.lock.Unlock()
+	close(done)
+
+	if stopping {
+		log.Printf("pipeline %s stopped \n", name)
+		return
+	}
+
+	interval := p.manager.restartInterval.Load()
+	log.Printf("pipeline %s exited: %s \n", name, lastError)
+	if interval < 0 {
+		return
+	}
+	time.Sleep(time.Duration(interval) * time.Second)
+
+	p.lock.Lock()
+	restart := p.state == StateExited
+	if restart {
+		p.restarts++
+	}
+	p.lock.Unlock()
+	if restart {
+		if err := p.start(); err != nil {
+			log.Println(errors.ErrorStack(err))
+		}
+	}
+}
+
+// stop 发送SIGTERM，子进程保存binlog位置后退出；超时后强制结束
+func (p *process) stop() {
+	p.lock.Lock()
+	if p.state != StateRunning {
+		p.state = StateStopped
+		p.lock.Unlock()
+		return
+	}
+	p.stopping = true
+	cmd, done, name := p.cmd, p.done, p.option.Name
+	p.lock.Unlock()
+
+	timeout := p.manager.stopTimeout.Load()
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(time.Duration(timeout) * time.Second):
+		log.Printf("pipeline %s not exited in %d seconds, kill it \n", name, timeout)
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+func (p *process) status() *Status {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	status := &Status{
+		Name:      p.option.Name,
+		Config:    p.option.Config,
+		Disable:   p.option.Disable,
+		State:     p.state,
+		Restarts:  p.restarts,
+		LastError: p.lastError,
+	}
+	if p.state == StateRunning {
+		status.Pid = p.cmd.Process.Pid
+		status.StartTime = dates.Layout(p.startTime, dates.DayTimeSecondFormatter)
+	}
+	return status
+}
+
+func copyWithPrefix(w io.Writer, r io.Reader, prefix string) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		io.WriteString(w, prefix+scanner.Text()+"\n")
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package service
+
+import (
+	"github.com/juju/errors"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/storage"
+)
+
+// 集中管理规则(cluster.central_rules)时，规则集的发布、回滚及查询，供命令行及web admin使用
+
+func ruleStorage() (storage.RuleStorage, error) {
+	if !global.Cfg().IsCentralRules() {
+		return nil, errors.New("central rules not enabled, set cluster.central_rules to true")
+	}
+	rs := storage.NewRuleStorage()
+	if err := rs.Initialize(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return rs, nil
+}
+
+// PublishRuleSet 校验并发布新的规则集，各节点监听到版本变化后热加载
+func PublishRuleSet(ruleSet []byte) (int, error) {
+	rs, err := ruleStorage()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := global.ParseRuleSet(ruleSet); err != nil {
+		return 0, errors.Annotate(err, "invalid rule set")
+	}
+	return rs.Publish(ruleSet)
+}
+
+// RollbackRuleSet 切换到指定版本，version为0时切换到当前版本的上一个版本
+func RollbackRuleSet(version int) (int, error) {
+	rs, err := ruleStorage()
+	if err != nil {
+		return 0, err
+	}
+	if version != 0 {
+		ruleSet, err := rs.Get(version)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := global.ParseRuleSet(ruleSet); err != nil {
+			return 0, errors.Annotatef(err, "invalid rule version %d", version)
+		}
+	}
+	return rs.Rollback(version)
+}
+
+// RuleSetVersions 返回全部版本及当前版本
+func RuleSetVersions() ([]int, int, error) {
+	rs, err := ruleStorage()
+	if err != nil {
+		return nil, 0, err
+	}
+	versions, err := rs.Versions()
+	if err != nil {
+		return nil, 0, err
+	}
+	current, _, err := rs.Current()
+	return versions, current, err
+}
+
+// RuleSet 返回指定版本的规则集内容
+func RuleSet(version int) ([]byte, error) {
+	rs, err := ruleStorage()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Get(version)
+}
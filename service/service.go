@@ -30,7 +30,8 @@ var (
 
 func Initialize() error {
 	transferService := &TransferService{
-		loopStopSignal: make(chan struct{}, 1),
+		loopStopSignal:    make(chan struct{}),
+		watcherStopSignal: make(chan struct{}),
 	}
 	err := transferService.initialize()
 	if err != nil {
@@ -56,6 +57,11 @@ func StartUp() {
 	}
 }
 
+// ReloadRules 热加载规则，由SIGHUP信号或web接口触发
+func ReloadRules() error {
+	return _transferService.Reload()
+}
+
 func Close() {
 	_transferService.Close()
 }
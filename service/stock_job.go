@@ -0,0 +1,140 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/util/dates"
+	"go-mysql-transfer/util/logs"
+)
+
+var (
+	_stockJob    *stockJob
+	_lockOfStock sync.Mutex
+)
+
+// stockJob 同步运行中通过管理接口触发的存量数据导入，同一时间只运行一个
+type stockJob struct {
+	lock      sync.Mutex
+	tables    []string
+	service   *StockService
+	startTime time.Time
+	endTime   time.Time
+	err       error
+}
+
+// StockJobStatus 存量数据导入的状态及进度
+type StockJobStatus struct {
+	Tables    []string         `json:"tables"`
+	Running   bool             `json:"running"`
+	StartTime string           `json:"startTime"`
+	EndTime   string           `json:"endTime"`
+	Error     string           `json:"error"`
+	TotalRows map[string]int64 `json:"totalRows"`
+	Imported  map[string]int64 `json:"imported"`
+}
+
+// tableRuleKeys 将schema.table形式的表名转换为规则的key
+func tableRuleKeys(tables []string) ([]string, error) {
+	keys := make([]string, 0, len(tables))
+	for _, table := range tables {
+		parts := strings.SplitN(table, ".", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("table %s must be like schema.table", table)
+		}
+		keys = append(keys, global.RuleKey(parts[0], parts[1]))
+	}
+	return keys, nil
+}
+
+// StartStock 后台导入指定表(schema.table)的存量数据
+func StartStock(tables []string) error {
+	if len(tables) == 0 {
+		return errors.New("empty tables not allowed")
+	}
+	keys, err := tableRuleKeys(tables)
+	if err != nil {
+		return err
+	}
+	if !_transferService.EndpointEnable() {
+		return errors.New("endpoint not available")
+	}
+
+	_lockOfStock.Lock()
+	defer _lockOfStock.Unlock()
+
+	if _stockJob != nil && _stockJob.status().Running {
+		return errors.New("stock import is running")
+	}
+
+	job := &stockJob{
+		tables:    tables,
+		service:   NewStockService(),
+		startTime: time.Now(),
+	}
+	_stockJob = job
+
+	go func() {
+		err := job.service.RunTables(keys)
+		if err != nil {
+			logs.Errorf("stock import %v: %s", tables, errors.ErrorStack(err))
+		}
+		job.lock.Lock()
+		job.err = err
+		job.endTime = time.Now()
+		job.lock.Unlock()
+	}()
+
+	return nil
+}
+
+// StockStatus 最近一次存量数据导入的状态，未导入过时返回nil
+func StockStatus() *StockJobStatus {
+	_lockOfStock.Lock()
+	defer _lockOfStock.Unlock()
+
+	if _stockJob == nil {
+		return nil
+	}
+	return _stockJob.status()
+}
+
+func (j *stockJob) status() *StockJobStatus {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	status := &StockJobStatus{
+		Tables:    j.tables,
+		Running:   j.endTime.IsZero(),
+		StartTime: dates.Layout(j.startTime, dates.DayTimeSecondFormatter),
+	}
+	status.TotalRows, status.Imported = j.service.Progress()
+	if !j.endTime.IsZero() {
+		status.EndTime = dates.Layout(j.endTime, dates.DayTimeSecondFormatter)
+	}
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+	return status
+}
@@ -56,7 +56,7 @@ func NewStockService() *StockService {
 	}
 }
 
-func (s *StockService) Run() error {
+func (s *StockService) canalConfig() *canal.Config {
 	canalCfg := canal.NewDefaultConfig()
 	canalCfg.Addr = global.Cfg().Addr
 	canalCfg.User = global.Cfg().User
@@ -67,8 +67,12 @@ func (s *StockService) Run() error {
 	canalCfg.Dump.ExecutionPath = global.Cfg().DumpExec
 	canalCfg.Dump.DiscardErr = false
 	canalCfg.Dump.SkipMasterData = global.Cfg().SkipMasterData
+	canalCfg.TLSConfig = global.Cfg().MysqlTLSConfig()
+	return canalCfg
+}
 
-	if c, err := canal.NewCanal(canalCfg); err != nil {
+func (s *StockService) Run() error {
+	if c, err := canal.NewCanal(s.canalConfig()); err != nil {
 		errors.Trace(err)
 	} else {
 		s.canal = c
@@ -87,8 +91,88 @@ func (s *StockService) Run() error {
 	s.endpoint = endpoint
 
 	startTime := dates.NowMillisecond()
+	if err := s.stock(global.RuleInsList()); err != nil {
+		return err
+	}
+
+	fmt.Println(fmt.Sprintf("共耗时 ：%d（毫秒）", dates.NowMillisecond()-startTime))
+
+	for k, v := range s.totalRows {
+		vv, ok := s.counter[k]
+		if ok {
+			fmt.Println(fmt.Sprintf("表： %s，共：%d 条数据，成功导入：%d 条", k, v, vv))
+			if v > vv {
+				fmt.Println("存在导入错误的数据，具体请至日志查看")
+			}
+		}
+	}
+
+	s.endpoint.Close() // 关闭客户端
+
+	return nil
+}
+
+// RunTables 同步运行中导入指定表的存量数据，使用当前的规则实例、Lua运行环境及接收端，
+// keys为规则的key(global.RuleKey)，对应的表必须已配置规则
+func (s *StockService) RunTables(keys []string) error {
+	canalCfg := s.canalConfig()
+	canalCfg.Dump.ExecutionPath = ""
+	c, err := canal.NewCanal(canalCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.canal = c
+	defer s.canal.Close()
+
+	rules := make([]*global.Rule, 0, len(keys))
+	for _, key := range keys {
+		rule, ok := global.RuleIns(key)
+		if !ok {
+			return errors.NotFoundf("rule of %s", key)
+		}
+		rules = append(rules, rule)
+	}
+
+	// 通过增量同步正在使用的接收端导入，不另建连接，也不在结束时关闭
+	s.endpoint = &liveEndpoint{service: _transferService}
+	if err := s.endpoint.Ping(); err != nil {
+		return err
+	}
+	if err := s.stock(rules); err != nil {
+		return err
+	}
+	if s.shutoff.Load() {
+		return errors.New("stock import failed, see the log file for details")
+	}
+
+	totalRows, imported := s.Progress()
+	for table, total := range totalRows {
+		if imported[table] < total {
+			return errors.Errorf("%s imported %d of %d rows, see the log file for details", table, imported[table], total)
+		}
+	}
+	return nil
+}
+
+// Progress 各表的总行数及已导入的行数
+func (s *StockService) Progress() (map[string]int64, map[string]int64) {
+	s.lockOfCounter.Lock()
+	defer s.lockOfCounter.Unlock()
+
+	totalRows := make(map[string]int64, len(s.totalRows))
+	for k, v := range s.totalRows {
+		totalRows[k] = v
+	}
+	counter := make(map[string]int64, len(s.counter))
+	for k, v := range s.counter {
+		counter[k] = v
+	}
+	return totalRows, counter
+}
+
+func (s *StockService) stock(rules []*global.Rule) error {
 	log.Println(fmt.Sprintf("bulk size: %d", global.Cfg().BulkSize))
-	for _, rule := range global.RuleInsList() {
+	for _, rule := range rules {
 		if rule.OrderByColumn == "" {
 			return errors.New("empty order_by_column not allowed")
 		}
@@ -102,10 +186,11 @@ func (s *StockService) Run() error {
 			return err
 		}
 		totalRow, err := res.GetInt(0, 0)
+		s.lockOfCounter.Lock()
 		s.totalRows[fullName] = totalRow
-		log.Println(fmt.Sprintf("%s 共 %d 条数据", fullName, totalRow))
-
 		s.counter[fullName] = 0
+		s.lockOfCounter.Unlock()
+		log.Println(fmt.Sprintf("%s 共 %d 条数据", fullName, totalRow))
 
 		var batch int64
 		size := global.Cfg().BulkSize
@@ -140,20 +225,6 @@ func (s *StockService) Run() error {
 
 	s.wg.Wait()
 
-	fmt.Println(fmt.Sprintf("共耗时 ：%d（毫秒）", dates.NowMillisecond()-startTime))
-
-	for k, v := range s.totalRows {
-		vv, ok := s.counter[k]
-		if ok {
-			fmt.Println(fmt.Sprintf("表： %s，共：%d 条数据，成功导入：%d 条", k, v, vv))
-			if v > vv {
-				fmt.Println("存在导入错误的数据，具体请至日志查看")
-			}
-		}
-	}
-
-	s.endpoint.Close() // 关闭客户端
-
 	return nil
 }
 
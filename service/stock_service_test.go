@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+
+	"go-mysql-transfer/model"
+)
+
+func TestLiveEndpointStock(t *testing.T) {
+	ts := &TransferService{endpoint: &fakeStockEndpoint{}}
+	e := &liveEndpoint{service: ts}
+	rows := []*model.RowRequest{{Row: []interface{}{1, "a"}}, {Row: []interface{}{2, "b"}}}
+
+	if n := e.Stock(rows); n != 0 {
+		t.Errorf("endpoint disabled, want 0 rows, got %d", n)
+	}
+
+	// 停止同步等操作持有lockOfCanal时，导入仍可继续，不会阻塞它们
+	ts.endpointEnable.Store(true)
+	ts.lockOfCanal.Lock()
+	defer ts.lockOfCanal.Unlock()
+	if n := e.Stock(rows); n != 2 {
+		t.Errorf("want 2 rows, got %d", n)
+	}
+}
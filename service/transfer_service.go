@@ -18,9 +18,12 @@
 package service
 
 import (
+	"crypto/sha1"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,12 +34,16 @@ import (
 
 	"go-mysql-transfer/global"
 	"go-mysql-transfer/metrics"
+	"go-mysql-transfer/model"
 	"go-mysql-transfer/service/endpoint"
 	"go-mysql-transfer/storage"
 	"go-mysql-transfer/util/logs"
 )
 
-const _transferLoopInterval = 1
+const (
+	_transferLoopInterval = 1
+	DrainTimeout          = 30 // 暂停时等待当前事务结束的最长时间(秒)
+)
 
 type TransferService struct {
 	canal        *canal.Canal
@@ -45,15 +52,36 @@ type TransferService struct {
 	canalEnable  atomic.Bool
 	lockOfCanal  sync.Mutex
 	firstsStart  atomic.Bool
+	switching    atomic.Int32 // 正在等待或持有lockOfCanal启动、停止、重建canal的调用数
+	stoppedAt    atomic.Int64 // 健康检查首次发现canal意外停止的时间(unix秒)，0表示未停止
 
 	wg             sync.WaitGroup
 	endpoint       endpoint.Endpoint
 	endpointEnable atomic.Bool
+	lockOfEndpoint sync.RWMutex // 替换、关闭接收端时持有写锁，API存量导入写入每批数据时持有读锁
 	positionDao    storage.PositionStorage
 	loopStopSignal chan struct{}
+	closeOnce      sync.Once
+
+	watcherStopSignal chan struct{}
+	ruleStorage       storage.RuleStorage
+	ruleVersion       atomic.Int64
+
+	paused atomic.Bool // 手动暂停，暂停期间不会因接收端恢复或当选leader而自动启动
+
+	heartbeat *heartbeat
+
+	shardDao    storage.ShardPositionStorage // 按表分片时本节点的位置
+	shardTables atomic.Value                 // 按表分片时本节点负责的表([]string，规则key)，由lockOfCanal保护写入
 }
 
 func (s *TransferService) initialize() error {
+	if global.Cfg().IsCentralRules() {
+		if err := s.loadRuleSet(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	s.canalCfg = canal.NewDefaultConfig()
 	s.canalCfg.Addr = global.Cfg().Addr
 	s.canalCfg.User = global.Cfg().User
@@ -64,11 +92,20 @@ func (s *TransferService) initialize() error {
 	s.canalCfg.Dump.ExecutionPath = global.Cfg().DumpExec
 	s.canalCfg.Dump.DiscardErr = false
 	s.canalCfg.Dump.SkipMasterData = global.Cfg().SkipMasterData
+	s.canalCfg.TLSConfig = global.Cfg().MysqlTLSConfig()
+	s.canalCfg.HeartbeatPeriod = time.Duration(global.Cfg().HeartbeatPeriod) * time.Second
 
 	if err := s.createCanal(); err != nil {
 		return errors.Trace(err)
 	}
 
+	if global.Cfg().IsHeartbeat() {
+		s.heartbeat = newHeartbeat()
+		if err := s.heartbeat.initialize(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	if err := s.completeRules(); err != nil {
 		return errors.Trace(err)
 	}
@@ -76,6 +113,10 @@ func (s *TransferService) initialize() error {
 	s.addDumpDatabaseOrTable()
 
 	positionDao := storage.NewPositionStorage()
+	if global.Cfg().IsSharding() {
+		s.shardDao = storage.NewShardPositionStorage(global.CurrentNode())
+		positionDao = s.shardDao
+	}
 	if err := positionDao.Initialize(); err != nil {
 		return errors.Trace(err)
 	}
@@ -99,6 +140,10 @@ func (s *TransferService) initialize() error {
 
 	s.firstsStart.Store(true)
 	s.startLoop()
+	s.startRuleWatcher()
+	if s.heartbeat != nil {
+		s.heartbeat.start()
+	}
 
 	return nil
 }
@@ -110,15 +155,13 @@ func (s *TransferService) run() error {
 	}
 
 	s.wg.Add(1)
-	go func(p mysql.Position) {
+	go func(p mysql.Position, h *handler) {
 		s.canalEnable.Store(true)
 		log.Println(fmt.Sprintf("transfer run from position(%s %d)", p.Name, p.Pos))
-		if err := s.canal.RunFrom(p); err != nil {
+		if err := s.canal.RunFrom(p); err != nil && !h.pausing.Load() {
 			log.Println(fmt.Sprintf("start transfer : %v", err))
 			logs.Errorf("canal : %v", errors.ErrorStack(err))
-			if s.canalHandler != nil {
-				s.canalHandler.stopListener()
-			}
+			h.stopListener()
 			s.canalEnable.Store(false)
 		}
 
@@ -126,7 +169,7 @@ func (s *TransferService) run() error {
 		s.canalEnable.Store(false)
 		s.canal = nil
 		s.wg.Done()
-	}(current)
+	}(current, s.canalHandler)
 
 	// canal未提供回调，停留一秒，确保RunFrom启动成功
 	time.Sleep(time.Second)
@@ -134,11 +177,25 @@ func (s *TransferService) run() error {
 }
 
 func (s *TransferService) StartUp() {
+	s.switching.Inc()
+	defer s.switching.Dec()
 	s.lockOfCanal.Lock()
 	defer s.lockOfCanal.Unlock()
 
+	if s.paused.Load() {
+		log.Println("transfer paused, skip start")
+		return
+	}
+
+	if global.Cfg().IsSharding() { // 初始化时的canal包含所有表，分片后按分配的表重建
+		if len(s.ShardTables()) > 0 {
+			s.restart()
+		}
+		return
+	}
+
 	if s.firstsStart.Load() {
-		s.canalHandler = newHandler()
+		s.canalHandler = newHandler(s.canal)
 		s.canal.SetEventHandler(s.canalHandler)
 		s.canalHandler.startListener()
 		s.firstsStart.Store(false)
@@ -154,15 +211,21 @@ func (s *TransferService) restart() {
 		s.wg.Wait()
 	}
 
-	s.createCanal()
+	if global.Cfg().IsSharding() {
+		s.createShardCanal()
+	} else {
+		s.createCanal()
+	}
 	s.addDumpDatabaseOrTable()
-	s.canalHandler = newHandler()
+	s.canalHandler = newHandler(s.canal)
 	s.canal.SetEventHandler(s.canalHandler)
 	s.canalHandler.startListener()
 	s.run()
 }
 
 func (s *TransferService) stopDump() {
+	s.switching.Inc()
+	defer s.switching.Dec()
 	s.lockOfCanal.Lock()
 	defer s.lockOfCanal.Unlock()
 
@@ -185,25 +248,188 @@ func (s *TransferService) stopDump() {
 	log.Println("dumper stopped")
 }
 
+// drainDump 在事务边界停止同步，提交已读取的数据并保存binlog位置
+func (s *TransferService) drainDump() {
+	s.switching.Inc()
+	defer s.switching.Dec()
+	s.lockOfCanal.Lock()
+	defer s.lockOfCanal.Unlock()
+
+	s.drain()
+}
+
+// drain 调用方需持有lockOfCanal
+func (s *TransferService) drain() {
+	c, h := s.canal, s.canalHandler
+	if c == nil || h == nil || !s.canalEnable.Load() {
+		return
+	}
+
+	h.pausing.Store(true)
+	deadline := time.Now().Add(DrainTimeout * time.Second)
+	for h.inTransaction() && s.canalEnable.Load() {
+		if time.Now().After(deadline) {
+			log.Println("wait for the end of transaction timeout, the transaction will be replayed after resume")
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Close()
+	s.wg.Wait()
+	h.drainListener()
+	s.canalHandler = nil
+
+	log.Println("dumper drained")
+}
+
+// Close 可能被多次调用(保存位置失败时及进程退出时)，只执行一次
 func (s *TransferService) Close() {
-	s.stopDump()
-	s.loopStopSignal <- struct{}{}
+	s.closeOnce.Do(func() {
+		if s.heartbeat != nil {
+			s.heartbeat.close()
+		}
+		s.drainDump()
+		close(s.loopStopSignal)
+		close(s.watcherStopSignal)
+	})
 }
 
 func (s *TransferService) Position() (mysql.Position, error) {
 	return s.positionDao.Get()
 }
 
+// Pause 暂停同步：在事务边界停止读取binlog，提交已读取的数据并保存位置，恢复前不会自动启动
+func (s *TransferService) Pause() {
+	s.paused.Store(true)
+	s.drainDump()
+	log.Println("transfer paused")
+}
+
+// Resume 恢复同步，从已保存的binlog位置继续；集群模式下只有leader恢复同步
+func (s *TransferService) Resume() {
+	if !s.paused.CAS(true, false) {
+		return
+	}
+	log.Println("transfer resumed")
+	if global.Cfg().IsCluster() && !global.Cfg().IsSharding() && !global.IsLeader() {
+		return
+	}
+	s.StartUp()
+}
+
+func (s *TransferService) Paused() bool {
+	return s.paused.Load()
+}
+
+func (s *TransferService) CanalEnable() bool {
+	return s.canalEnable.Load()
+}
+
+func (s *TransferService) EndpointEnable() bool {
+	return s.endpointEnable.Load()
+}
+
+// Switching 是否正在启动、停止或重建canal(暂停、重载、重新分片等)
+func (s *TransferService) Switching() bool {
+	return s.switching.Load() > 0
+}
+
+// liveEndpoint API存量导入使用的接收端，每次调用时持有lockOfEndpoint读锁转发给增量同步正在使用的接收端，
+// 避免两个实例同时写入相同的文件；不持有lockOfCanal，导入期间仍可随时停止同步。Close不关闭接收端
+type liveEndpoint struct {
+	service *TransferService
+}
+
+func (e *liveEndpoint) current() (endpoint.Endpoint, error) {
+	if e.service.endpoint == nil || !e.service.endpointEnable.Load() {
+		return nil, errors.New("endpoint not available")
+	}
+	return e.service.endpoint, nil
+}
+
+func (e *liveEndpoint) Connect() error {
+	return e.Ping()
+}
+
+func (e *liveEndpoint) Ping() error {
+	e.service.lockOfEndpoint.RLock()
+	defer e.service.lockOfEndpoint.RUnlock()
+
+	_, err := e.current()
+	return err
+}
+
+func (e *liveEndpoint) Consume(from mysql.Position, rows []*model.RowRequest) error {
+	return errors.NotSupportedf("consume through stock endpoint")
+}
+
+func (e *liveEndpoint) Stock(rows []*model.RowRequest) int64 {
+	e.service.lockOfEndpoint.RLock()
+	defer e.service.lockOfEndpoint.RUnlock()
+
+	ep, err := e.current()
+	if err != nil {
+		logs.Error(err.Error())
+		return 0
+	}
+	return ep.Stock(rows)
+}
+
+func (e *liveEndpoint) Close() {}
+
+// SetPosition 设置binlog位置，同步运行中时停止后从新的位置重新开始
+func (s *TransferService) SetPosition(pos mysql.Position) error {
+	if global.Cfg().IsCluster() && !global.Cfg().IsSharding() && !global.IsLeader() {
+		return errors.Errorf("not the leader, leader is %s", global.LeaderNode())
+	}
+
+	running := s.canalEnable.Load()
+	s.drainDump()
+	if err := s.positionDao.Save(pos); err != nil {
+		return errors.Trace(err)
+	}
+	log.Println(fmt.Sprintf("set position %s %d", pos.Name, pos.Pos))
+
+	if running {
+		s.StartUp()
+	}
+	return nil
+}
+
 func (s *TransferService) createCanal() error {
+	regex := make([]string, 0, len(global.Cfg().RuleConfigs))
 	for _, rc := range global.Cfg().RuleConfigs {
-		s.canalCfg.IncludeTableRegex = append(s.canalCfg.IncludeTableRegex, rc.Schema+"\\."+rc.Table)
+		regex = append(regex, rc.Schema+"\\."+rc.Table)
+	}
+	return s.newCanal(regex)
+}
+
+// createShardCanal 按表分片时只读取分配给本节点的表，规则实例需已创建
+func (s *TransferService) createShardCanal() error {
+	tables := s.ShardTables()
+	regex := make([]string, 0, len(tables))
+	for _, key := range tables {
+		if rule, ok := global.RuleIns(key); ok {
+			regex = append(regex, regexp.QuoteMeta(rule.Schema)+"\\."+regexp.QuoteMeta(rule.Table))
+		}
 	}
+	return s.newCanal(regex)
+}
+
+func (s *TransferService) newCanal(regex []string) error {
+	if global.Cfg().IsHeartbeat() {
+		schema, table := global.Cfg().HeartbeatSchemaTable()
+		regex = append(regex, regexp.QuoteMeta(schema)+"\\."+regexp.QuoteMeta(table))
+	}
+	s.canalCfg.IncludeTableRegex = regex
 	var err error
 	s.canal, err = canal.NewCanal(s.canalCfg)
 	return errors.Trace(err)
 }
 
+// completeRules 根据规则配置创建规则实例，全部成功后才替换当前的规则实例
 func (s *TransferService) completeRules() error {
+	rules := make(map[string]*global.Rule)
 	wildcards := make(map[string]bool)
 	for _, rc := range global.Cfg().RuleConfigs {
 		if rc.Table == "*" {
@@ -215,25 +441,18 @@ func (s *TransferService) completeRules() error {
 				return errors.Errorf("duplicate wildcard table defined for %s.%s", rc.Schema, rc.Table)
 			}
 
-			tableName := rc.Table
-			if rc.Table == "*" {
-				tableName = "." + rc.Table
-			}
-			sql := fmt.Sprintf(`SELECT table_name FROM information_schema.tables WHERE
-					table_name RLIKE "%s" AND table_schema = "%s";`, tableName, rc.Schema)
-			res, err := s.canal.Execute(sql)
+			tableNames, err := wildcardTables(s.canal, rc.Schema, rc.Table)
 			if err != nil {
 				return errors.Trace(err)
 			}
-			for i := 0; i < res.Resultset.RowNumber(); i++ {
-				tableName, _ := res.GetString(i, 0)
+			for _, tableName := range tableNames {
 				newRule, err := global.RuleDeepClone(rc)
 				if err != nil {
 					return errors.Trace(err)
 				}
 				newRule.Table = tableName
 				ruleKey := global.RuleKey(rc.Schema, tableName)
-				global.AddRuleIns(ruleKey, newRule)
+				rules[ruleKey] = newRule
 			}
 		} else {
 			newRule, err := global.RuleDeepClone(rc)
@@ -241,11 +460,11 @@ func (s *TransferService) completeRules() error {
 				return errors.Trace(err)
 			}
 			ruleKey := global.RuleKey(rc.Schema, rc.Table)
-			global.AddRuleIns(ruleKey, newRule)
+			rules[ruleKey] = newRule
 		}
 	}
 
-	for _, rule := range global.RuleInsList() {
+	for _, rule := range rules {
 		tableMata, err := s.canal.GetTable(rule.Schema, rule.Table)
 		if err != nil {
 			return errors.Trace(err)
@@ -272,14 +491,36 @@ func (s *TransferService) completeRules() error {
 		}
 	}
 
+	global.ReplaceRuleIns(rules)
 	return nil
 }
 
+// wildcardTables 查询与通配符表名匹配的表
+func wildcardTables(c *canal.Canal, schema, table string) ([]string, error) {
+	tableName := table
+	if table == "*" {
+		tableName = "." + table
+	}
+	sql := fmt.Sprintf(`SELECT table_name FROM information_schema.tables WHERE
+					table_name RLIKE "%s" AND table_schema = "%s";`, tableName, schema)
+	res, err := c.Execute(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0, res.Resultset.RowNumber())
+	for i := 0; i < res.Resultset.RowNumber(); i++ {
+		name, _ := res.GetString(i, 0)
+		tables = append(tables, name)
+	}
+	return tables, nil
+}
+
 func (s *TransferService) addDumpDatabaseOrTable() {
 	var schema string
 	schemas := make(map[string]int)
 	tables := make([]string, 0, global.RuleInsTotal())
-	for _, rule := range global.RuleInsList() {
+	for _, rule := range s.dumpRules() {
 		schema = rule.Table
 		schemas[rule.Schema] = 1
 		tables = append(tables, rule.Table)
@@ -295,6 +536,272 @@ func (s *TransferService) addDumpDatabaseOrTable() {
 	}
 }
 
+// dumpRules 需要dump的规则，按表分片时只包含分配给本节点的表
+func (s *TransferService) dumpRules() []*global.Rule {
+	if !global.Cfg().IsSharding() {
+		return global.RuleInsList()
+	}
+	tables := s.ShardTables()
+	rules := make([]*global.Rule, 0, len(tables))
+	for _, key := range tables {
+		if rule, ok := global.RuleIns(key); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// ShardTables 按表分片时本节点负责的表(规则key)
+func (s *TransferService) ShardTables() []string {
+	tables, _ := s.shardTables.Load().([]string)
+	return tables
+}
+
+// Reshard 按表分片时替换本节点负责的表：在事务边界停止同步，
+// 从新分配的表中最早的位置重新开始，没有分配到表时只停止同步
+func (s *TransferService) Reshard(tables []string) error {
+	s.switching.Inc()
+	defer s.switching.Dec()
+	s.lockOfCanal.Lock()
+	defer s.lockOfCanal.Unlock()
+
+	if current := s.ShardTables(); current != nil && strings.Join(current, ",") == strings.Join(tables, ",") {
+		return nil
+	}
+
+	s.drain()
+	if s.canalHandler != nil {
+		s.canalHandler.stopListener()
+		s.canalHandler = nil
+	}
+
+	pos, err := s.shardDao.Assign(tables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.shardTables.Store(tables)
+	log.Println(fmt.Sprintf("assigned tables: %v, position(%s %d)", tables, pos.Name, pos.Pos))
+
+	if len(tables) == 0 || s.paused.Load() || !s.endpointEnable.Load() {
+		return nil
+	}
+	s.restart()
+	return nil
+}
+
+// Reload 热加载规则：集中管理规则时重新读取集群中的当前版本，否则重新读取规则文件
+func (s *TransferService) Reload() error {
+	if global.Cfg().IsCentralRules() {
+		version, data, err := s.ruleStorage.Current()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if version == 0 {
+			return errors.New("no rule set published in cluster")
+		}
+		return s.applyRuleSet(version, data)
+	}
+
+	rcs, err := global.LoadRuleConfigs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c := *global.Cfg()
+	c.RuleConfigs = rcs
+	return s.reload(&c)
+}
+
+// applyRuleSet 热加载集群中指定版本的规则集
+func (s *TransferService) applyRuleSet(version int, data []byte) error {
+	c, err := global.ParseRuleSet(data)
+	if err != nil {
+		return errors.Annotatef(err, "rule version %d", version)
+	}
+	if err := s.reload(c); err != nil {
+		return errors.Annotatef(err, "rule version %d", version)
+	}
+	s.ruleVersion.Store(int64(version))
+	log.Println(fmt.Sprintf("rule version %d applied", version))
+	return nil
+}
+
+// reload 停止同步(正在处理的批次完成后)，替换配置、规则实例并刷新接收端，
+// 然后从已保存的binlog位置继续同步；新配置无效时恢复原配置
+func (s *TransferService) reload(c *global.Config) error {
+	s.switching.Inc()
+	defer s.switching.Dec()
+	s.lockOfCanal.Lock()
+	defer s.lockOfCanal.Unlock()
+
+	old := *global.Cfg()
+	added, removed, modified := global.DiffRuleConfigs(old.RuleConfigs, c.RuleConfigs)
+	targetChanged := global.TargetChanged(&old, c)
+	if len(added) == 0 && len(removed) == 0 && len(modified) == 0 && !targetChanged {
+		logs.Info("rules not changed")
+		return nil
+	}
+	log.Println(fmt.Sprintf("reload rules, added: %v, removed: %v, modified: %v, target changed: %v",
+		added, removed, modified, targetChanged))
+
+	running := s.canal != nil && s.canalEnable.Load()
+	s.drain()
+	if s.canalHandler != nil {
+		s.canalHandler.stopListener()
+		s.canalHandler = nil
+	}
+	if s.canal != nil { // 未运行的canal
+		s.canal.Close()
+	}
+
+	global.ReplaceConfig(c)
+	err := s.applyConfig(targetChanged)
+	if err != nil {
+		logs.Errorf("reload rules failed, rollback: %s", errors.ErrorStack(err))
+		global.ReplaceConfig(&old)
+		if rollbackErr := s.applyConfig(targetChanged); rollbackErr != nil {
+			logs.Errorf("rollback rules failed: %s", errors.ErrorStack(rollbackErr))
+			return errors.Trace(rollbackErr)
+		}
+	}
+
+	if running && global.Cfg().IsSharding() { // 新增、删除的表在下次分片时分配
+		s.canal.Close()
+		if err := s.createShardCanal(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if running {
+		s.canalHandler = newHandler(s.canal)
+		s.canal.SetEventHandler(s.canalHandler)
+		s.canalHandler.startListener()
+		if runErr := s.run(); runErr != nil {
+			return errors.Trace(runErr)
+		}
+	}
+
+	return errors.Trace(err)
+}
+
+// applyConfig 按当前配置重建canal及规则实例，接收端配置变化时重建接收端
+func (s *TransferService) applyConfig(rebuildEndpoint bool) error {
+	if err := s.createCanal(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := s.completeRules(); err != nil {
+		return errors.Trace(err)
+	}
+	s.addDumpDatabaseOrTable()
+
+	if rebuildEndpoint {
+		s.lockOfEndpoint.Lock()
+		defer s.lockOfEndpoint.Unlock()
+
+		s.endpoint.Close()
+		ep := endpoint.NewEndpoint(s.canal)
+		if err := ep.Connect(); err != nil {
+			return errors.Trace(err)
+		}
+		s.endpoint = ep
+		return nil
+	}
+
+	if refresher, ok := s.endpoint.(endpoint.RuleRefresher); ok {
+		if err := refresher.RefreshRules(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// loadRuleSet 集中管理规则时，启动前加载集群中的当前规则集，尚未发布过时使用本地规则
+func (s *TransferService) loadRuleSet() error {
+	rs := storage.NewRuleStorage()
+	if err := rs.Initialize(); err != nil {
+		return errors.Trace(err)
+	}
+	s.ruleStorage = rs
+
+	version, data, err := rs.Current()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if version == 0 {
+		log.Println("no rule set published in cluster, use local rules")
+		return nil
+	}
+
+	c, err := global.ParseRuleSet(data)
+	if err != nil {
+		return errors.Annotatef(err, "rule version %d", version)
+	}
+	global.ReplaceConfig(c)
+	s.ruleVersion.Store(int64(version))
+	log.Println(fmt.Sprintf("use rule version %d in cluster", version))
+	return nil
+}
+
+// RuleVersion 当前生效的集群规则集版本，使用本地规则时为0
+func (s *TransferService) RuleVersion() int {
+	return int(s.ruleVersion.Load())
+}
+
+// startRuleWatcher 集中管理规则时监听集群中的当前版本，否则定时检查规则文件的内容，发生变化时热加载
+func (s *TransferService) startRuleWatcher() {
+	if global.Cfg().IsCentralRules() {
+		go func() {
+			for version := range s.ruleStorage.Watch(s.watcherStopSignal) {
+				if version == 0 || version == s.RuleVersion() {
+					continue
+				}
+				data, err := s.ruleStorage.Get(version)
+				if err == nil {
+					err = s.applyRuleSet(version, data)
+				}
+				if err != nil {
+					logs.Errorf("apply rule version %d: %s", version, errors.ErrorStack(err))
+				}
+			}
+		}()
+		return
+	}
+
+	interval := global.Cfg().RuleWatchInterval
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+		last := fileDigest(global.RuleSourceFile())
+		for {
+			select {
+			case <-ticker.C:
+				digest := fileDigest(global.RuleSourceFile())
+				if digest == "" || digest == last {
+					continue
+				}
+				last = digest
+				if err := s.Reload(); err != nil {
+					logs.Errorf("reload rules: %s", errors.ErrorStack(err))
+				}
+			case <-s.watcherStopSignal:
+				return
+			}
+		}
+	}()
+}
+
+func fileDigest(fileName string) string {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		logs.Warnf("read %s: %s", fileName, err.Error())
+		return ""
+	}
+	return fmt.Sprintf("%x", sha1.Sum(data))
+}
+
 func (s *TransferService) updateRule(schema, table string) error {
 	rule, ok := global.RuleIns(global.RuleKey(schema, table))
 	if ok {
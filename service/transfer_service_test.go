@@ -0,0 +1,32 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferServiceCloseTwice(t *testing.T) {
+	s := &TransferService{
+		loopStopSignal:    make(chan struct{}),
+		watcherStopSignal: make(chan struct{}),
+		heartbeat:         &heartbeat{stop: make(chan struct{})},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second Close blocked")
+	}
+
+	select {
+	case <-s.loopStopSignal:
+	default:
+		t.Error("loop not signalled to stop")
+	}
+}
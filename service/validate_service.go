@@ -0,0 +1,140 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package service
+
+import (
+	"regexp"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/canal"
+
+	"go-mysql-transfer/global"
+)
+
+// ValidateResult 单条规则配置的校验结果
+type ValidateResult struct {
+	Schema string
+	Table  string           // 规则中配置的表名，可能为通配符
+	Tables map[string]error // 匹配到的表及其校验结果，nil表示通过
+	Err    error            // 规则级别的错误，如Lua编译失败、通配符查询失败
+}
+
+func (r *ValidateResult) Ok() bool {
+	if r.Err != nil || len(r.Tables) == 0 {
+		return false
+	}
+	for _, err := range r.Tables {
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate 只读连接MySQL，按规则匹配表并校验列、模板及Lua脚本，不启动同步；
+// 返回的error表示无法完成校验(如MySQL连接失败)
+func Validate() ([]*ValidateResult, error) {
+	cfg := canal.NewDefaultConfig()
+	cfg.Addr = global.Cfg().Addr
+	cfg.User = global.Cfg().User
+	cfg.Password = global.Cfg().Password
+	cfg.Charset = global.Cfg().Charset
+	cfg.Flavor = global.Cfg().Flavor
+	cfg.ServerID = global.Cfg().SlaveID
+	cfg.Dump.ExecutionPath = ""
+	cfg.TLSConfig = global.Cfg().MysqlTLSConfig()
+
+	c, err := canal.NewCanal(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer c.Close()
+
+	results := make([]*ValidateResult, 0, len(global.Cfg().RuleConfigs))
+	for _, rc := range global.Cfg().RuleConfigs {
+		results = append(results, validateRule(c, rc))
+	}
+	return results, nil
+}
+
+func validateRule(c *canal.Canal, rc *global.Rule) *ValidateResult {
+	result := &ValidateResult{
+		Schema: rc.Schema,
+		Table:  rc.Table,
+		Tables: make(map[string]error),
+	}
+
+	if rc.Table == "*" {
+		result.Err = errors.Errorf("wildcard * is not allowed for table name")
+		return result
+	}
+
+	tables := []string{rc.Table}
+	if regexp.QuoteMeta(rc.Table) != rc.Table { //通配符
+		matched, err := wildcardTables(c, rc.Schema, rc.Table)
+		if err != nil {
+			result.Err = errors.Trace(err)
+			return result
+		}
+		if len(matched) == 0 {
+			result.Err = errors.Errorf("no table matched %s.%s", rc.Schema, rc.Table)
+			return result
+		}
+		tables = matched
+	}
+
+	for _, table := range tables {
+		result.Tables[table] = validateTable(c, rc, table)
+	}
+
+	if rc.LuaEnable() {
+		rule, err := global.RuleDeepClone(rc)
+		if err == nil {
+			err = rule.CompileLuaScript(global.Cfg().DataDir)
+		}
+		if err != nil {
+			result.Err = errors.Annotate(err, "compile lua script")
+		}
+	}
+
+	return result
+}
+
+// validateTable 与TransferService.completeRules中的规则初始化一致
+func validateTable(c *canal.Canal, rc *global.Rule, table string) error {
+	rule, err := global.RuleDeepClone(rc)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	rule.Table = table
+
+	tableMata, err := c.GetTable(rule.Schema, rule.Table)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(tableMata.PKColumns) == 0 && !global.Cfg().SkipNoPkTable {
+		return errors.Errorf("%s.%s must have a PK for a column", rule.Schema, rule.Table)
+	}
+	if len(tableMata.PKColumns) > 1 {
+		rule.IsCompositeKey = true
+	}
+	rule.TableInfo = tableMata
+	rule.TableColumnSize = len(tableMata.Columns)
+
+	return rule.Initialize()
+}
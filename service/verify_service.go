@@ -0,0 +1,315 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/service/endpoint"
+)
+
+const (
+	_verifySamples   = 20      // 报告中每类差异最多列出的数据标识个数
+	_verifySeenLimit = 1000000 // 检查多出的数据时最多在内存中记录的数据标识个数
+
+	VerifyMissing   = "missing"
+	VerifyDifferent = "different"
+	VerifyExtra     = "extra"
+)
+
+// VerifyReport 单表的一致性校验结果
+type VerifyReport struct {
+	Schema       string
+	Table        string
+	Rows         int64               // MySQL中的行数
+	Missing      int64               // 接收端缺少的行数
+	Different    int64               // 内容不一致的行数
+	Extra        int64               // 接收端中多出的数据数
+	ExtraChecked bool                // 是否检查了接收端中多出的数据
+	ExtraCounted bool                // 数据标识超过上限未全部记录，多出的数据只按数量统计，不列出标识
+	Repaired     int64               // 通过Stock修复的行数
+	Samples      map[string][]string // 各类差异的部分数据标识，key为missing、different、extra
+	Err          error
+}
+
+// Ok 数据一致，或缺少、不一致的数据已全部修复
+func (r *VerifyReport) Ok() bool {
+	return r.Err == nil && r.Missing+r.Different == r.Repaired && r.Extra == 0
+}
+
+func (r *VerifyReport) sample(kind, key string) {
+	if len(r.Samples[kind]) < _verifySamples {
+		r.Samples[kind] = append(r.Samples[kind], key)
+	}
+}
+
+// VerifyService 一致性校验：按主键分段读取MySQL中的数据，按规则转换后与接收端中的数据比较；
+// 接收端需实现endpoint.Verifier。repair为true时通过Endpoint.Stock重新写入缺少及不一致的数据，
+// 接收端中多出的数据只报告，不自动删除
+type VerifyService struct {
+	canal     *canal.Canal
+	executor  sqlExecutor
+	endpoint  endpoint.Endpoint
+	verifier  endpoint.Verifier
+	repair    bool
+	seenLimit int
+}
+
+// sqlExecutor 执行SQL，由canal.Canal实现
+type sqlExecutor interface {
+	Execute(cmd string, args ...interface{}) (*mysql.Result, error)
+}
+
+func NewVerifyService(repair bool) *VerifyService {
+	return &VerifyService{
+		repair:    repair,
+		seenLimit: _verifySeenLimit,
+	}
+}
+
+// Run 校验tables(schema.table)，为空时校验全部规则；返回的error表示无法开始校验(如连接失败)
+func (s *VerifyService) Run(tables []string) ([]*VerifyReport, error) {
+	stock := NewStockService()
+	canalCfg := stock.canalConfig()
+	canalCfg.Dump.ExecutionPath = ""
+	c, err := canal.NewCanal(canalCfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.canal = c
+	s.executor = c
+	stock.canal = c
+	if err := stock.completeRules(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rules := global.RuleInsList()
+	if len(tables) > 0 {
+		keys, err := tableRuleKeys(tables)
+		if err != nil {
+			return nil, err
+		}
+		rules = make([]*global.Rule, 0, len(keys))
+		for _, key := range keys {
+			rule, ok := global.RuleIns(key)
+			if !ok {
+				return nil, errors.NotFoundf("rule of %s", key)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	ep := endpoint.NewEndpoint(c)
+	verifier, ok := ep.(endpoint.Verifier)
+	if !ok {
+		return nil, errors.NotSupportedf("verify for target %s", global.Cfg().Target)
+	}
+	if err := ep.Connect(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.endpoint = ep
+	s.verifier = verifier
+
+	reports := make([]*VerifyReport, 0, len(rules))
+	for _, rule := range rules {
+		log.Println(fmt.Sprintf("开始校验 %s.%s", rule.Schema, rule.Table))
+		report := s.verify(rule)
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func (s *VerifyService) verify(rule *global.Rule) *VerifyReport {
+	report := &VerifyReport{
+		Schema:  rule.Schema,
+		Table:   rule.Table,
+		Samples: make(map[string][]string),
+	}
+	if rule.LuaEnable() {
+		report.Err = errors.NotSupportedf("verify rule with lua script")
+		return report
+	}
+	if len(rule.TableInfo.PKColumns) == 0 {
+		report.Err = errors.Errorf("%s.%s has no primary key", rule.Schema, rule.Table)
+		return report
+	}
+
+	// MySQL中存在的数据标识，用于列出接收端中多出的数据；超过seenLimit后不再记录，只按数量统计
+	seen := make(map[string]struct{})
+	var found int64 // MySQL中的数据在接收端存在(含修复写入)的行数
+	var last []interface{}
+	for {
+		rows, err := s.chunk(rule, last)
+		if err != nil {
+			report.Err = err
+			return report
+		}
+		if len(rows) == 0 {
+			break
+		}
+		report.Rows += int64(len(rows))
+		last = pkValues(rows[len(rows)-1], rule)
+
+		records, err := s.verifier.Fetch(rule, rows)
+		if err != nil {
+			report.Err = err
+			return report
+		}
+
+		var missing, different []*model.RowRequest
+		for i, record := range records {
+			if seen != nil {
+				seen[record.Key] = struct{}{}
+			}
+			if record.Actual == nil {
+				report.Missing++
+				report.sample(VerifyMissing, record.Key)
+				missing = append(missing, rows[i])
+				continue
+			}
+			found++
+			if !record.Equal() {
+				report.Different++
+				report.sample(VerifyDifferent, record.Key)
+				different = append(different, rows[i])
+			}
+		}
+		if s.repair && len(missing) > 0 {
+			repaired := s.endpoint.Stock(missing)
+			report.Repaired += repaired
+			found += repaired
+		}
+		if s.repair && len(different) > 0 {
+			report.Repaired += s.endpoint.Stock(different)
+		}
+		if seen != nil && len(seen) > s.seenLimit {
+			seen = nil
+			report.ExtraCounted = true
+		}
+
+		if int64(len(rows)) < global.Cfg().BulkSize {
+			break
+		}
+	}
+
+	var total int64
+	err := s.verifier.Keys(rule, func(keys []string) error {
+		total += int64(len(keys))
+		if seen == nil {
+			return nil
+		}
+		for _, key := range keys {
+			if _, ok := seen[key]; !ok {
+				report.Extra++
+				report.sample(VerifyExtra, key)
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.IsNotSupported(err) {
+		report.Err = err
+		return report
+	}
+	report.ExtraChecked = err == nil
+	if report.ExtraCounted && total > found {
+		report.Extra = total - found
+	}
+	return report
+}
+
+// chunk 按主键顺序读取last之后的一段数据
+func (s *VerifyService) chunk(rule *global.Rule, last []interface{}) ([]*model.RowRequest, error) {
+	pks := make([]string, 0, len(rule.TableInfo.PKColumns))
+	for _, i := range rule.TableInfo.PKColumns {
+		pks = append(pks, "`"+rule.TableInfo.GetPKColumn(i).Name+"`")
+	}
+	columns := strings.Join(pks, ",")
+
+	sql := fmt.Sprintf("SELECT * FROM `%s`.`%s`", rule.Schema, rule.Table)
+	if last != nil {
+		values := make([]string, 0, len(last))
+		for _, v := range last {
+			values = append(values, sqlLiteral(v))
+		}
+		sql += fmt.Sprintf(" WHERE (%s) > (%s)", columns, strings.Join(values, ","))
+	}
+	sql += fmt.Sprintf(" ORDER BY %s LIMIT %d", columns, global.Cfg().BulkSize)
+
+	res, err := s.executor.Execute(sql)
+	if err != nil {
+		return nil, errors.Annotatef(err, "verify sql: %s", sql)
+	}
+
+	rowNumber := res.RowNumber()
+	rows := make([]*model.RowRequest, 0, rowNumber)
+	ruleKey := global.RuleKey(rule.Schema, rule.Table)
+	for i := 0; i < rowNumber; i++ {
+		values := make([]interface{}, 0, len(rule.TableInfo.Columns))
+		for j := 0; j < len(rule.TableInfo.Columns); j++ {
+			val, err := res.GetValue(i, j)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			values = append(values, val)
+		}
+		rows = append(rows, &model.RowRequest{
+			RuleKey: ruleKey,
+			Action:  canal.InsertAction,
+			Row:     values,
+		})
+	}
+	return rows, nil
+}
+
+func (s *VerifyService) Close() {
+	if s.endpoint != nil {
+		s.endpoint.Close()
+	}
+	if s.canal != nil {
+		s.canal.Close()
+	}
+}
+
+func pkValues(row *model.RowRequest, rule *global.Rule) []interface{} {
+	values := make([]interface{}, 0, len(rule.TableInfo.PKColumns))
+	for _, i := range rule.TableInfo.PKColumns {
+		values = append(values, row.Row[i])
+	}
+	return values
+}
+
+func sqlLiteral(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + mysql.Escape(vv) + "'"
+	case []byte:
+		return "'" + mysql.Escape(string(vv)) + "'"
+	default:
+		return fmt.Sprint(vv)
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/model"
+	"go-mysql-transfer/service/endpoint"
+)
+
+// pagedExecutor 按调用顺序返回各段数据，并记录执行的SQL
+type pagedExecutor struct {
+	pages [][][]interface{}
+	sqls  []string
+}
+
+func (e *pagedExecutor) Execute(cmd string, args ...interface{}) (*mysql.Result, error) {
+	e.sqls = append(e.sqls, cmd)
+	var page [][]interface{}
+	if len(e.pages) > 0 {
+		page, e.pages = e.pages[0], e.pages[1:]
+	}
+
+	rs, err := mysql.BuildSimpleTextResultset([]string{"user_id", "order_no", "amount"}, page)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range rs.RowDatas {
+		values, err := data.Parse(rs.Fields, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		rs.Values = append(rs.Values, values)
+	}
+	return &mysql.Result{Resultset: rs}, nil
+}
+
+// fakeVerifier missing中的数据接收端不存在，different中的数据与MySQL不一致
+type fakeVerifier struct {
+	missing   map[string]bool
+	different map[string]bool
+	keys      []string
+}
+
+func verifyKey(row *model.RowRequest) string {
+	return fmt.Sprintf("%v:%s", row.Row[0], row.Row[1])
+}
+
+func (v *fakeVerifier) Fetch(rule *global.Rule, rows []*model.RowRequest) ([]*endpoint.VerifyRecord, error) {
+	records := make([]*endpoint.VerifyRecord, 0, len(rows))
+	for _, row := range rows {
+		key := verifyKey(row)
+		record := &endpoint.VerifyRecord{Key: key, Expected: key}
+		if !v.missing[key] {
+			record.Actual = key
+			if v.different[key] {
+				record.Actual = key + "-old"
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (v *fakeVerifier) Keys(rule *global.Rule, fn func(keys []string) error) error {
+	return fn(v.keys)
+}
+
+// fakeStockEndpoint 写入failed以外的数据
+type fakeStockEndpoint struct {
+	failed map[string]bool
+}
+
+func (e *fakeStockEndpoint) Connect() error { return nil }
+
+func (e *fakeStockEndpoint) Ping() error { return nil }
+
+func (e *fakeStockEndpoint) Consume(mysql.Position, []*model.RowRequest) error { return nil }
+
+func (e *fakeStockEndpoint) Stock(rows []*model.RowRequest) int64 {
+	var sum int64
+	for _, row := range rows {
+		if !e.failed[verifyKey(row)] {
+			sum++
+		}
+	}
+	return sum
+}
+
+func (e *fakeStockEndpoint) Close() {}
+
+func testVerifyRule() *global.Rule {
+	return &global.Rule{
+		Schema: "shop",
+		Table:  "t_order",
+		TableInfo: &schema.Table{
+			Schema: "shop",
+			Name:   "t_order",
+			Columns: []schema.TableColumn{
+				{Name: "user_id", Type: schema.TYPE_NUMBER},
+				{Name: "order_no", Type: schema.TYPE_STRING},
+				{Name: "amount", Type: schema.TYPE_NUMBER},
+			},
+			PKColumns: []int{0, 1},
+		},
+	}
+}
+
+func testVerifyService(seenLimit int) (*VerifyService, *pagedExecutor) {
+	global.ReplaceConfig(&global.Config{BulkSize: 2})
+	executor := &pagedExecutor{pages: [][][]interface{}{
+		{{int64(1), "a", int64(10)}, {int64(1), "b", int64(20)}},
+		{{int64(2), "a", int64(30)}, {int64(2), "it's", int64(40)}},
+		{{int64(3), "a", int64(50)}},
+	}}
+	s := &VerifyService{
+		executor: executor,
+		verifier: &fakeVerifier{
+			missing:   map[string]bool{"2:a": true},
+			different: map[string]bool{"3:a": true, "1:b": true},
+			keys:      []string{"1:a", "1:b", "2:a", "2:it's", "3:a", "9:z"},
+		},
+		endpoint:  &fakeStockEndpoint{failed: map[string]bool{"3:a": true}},
+		repair:    true,
+		seenLimit: seenLimit,
+	}
+	return s, executor
+}
+
+func TestVerifyCompositeKeyChunks(t *testing.T) {
+	s, executor := testVerifyService(_verifySeenLimit)
+	report := s.verify(testVerifyRule())
+	if report.Err != nil {
+		t.Fatal(report.Err)
+	}
+
+	want := []string{
+		"SELECT * FROM `shop`.`t_order` ORDER BY `user_id`,`order_no` LIMIT 2",
+		"SELECT * FROM `shop`.`t_order` WHERE (`user_id`,`order_no`) > (1,'b') ORDER BY `user_id`,`order_no` LIMIT 2",
+		"SELECT * FROM `shop`.`t_order` WHERE (`user_id`,`order_no`) > (2,'it\\'s') ORDER BY `user_id`,`order_no` LIMIT 2",
+	}
+	if len(executor.sqls) != len(want) {
+		t.Fatalf("want %d chunks, got %v", len(want), executor.sqls)
+	}
+	for i := range want {
+		if executor.sqls[i] != want[i] {
+			t.Errorf("chunk %d:\nwant %s\ngot  %s", i, want[i], executor.sqls[i])
+		}
+	}
+
+	if report.Rows != 5 || report.Missing != 1 || report.Different != 2 {
+		t.Errorf("unexpected counts: %+v", report)
+	}
+	// 3:a写入失败，只修复了2:a及1:b
+	if report.Repaired != 2 || report.Ok() {
+		t.Errorf("unexpected repair result: repaired %d, ok %v", report.Repaired, report.Ok())
+	}
+	if !report.ExtraChecked || report.ExtraCounted || report.Extra != 1 || report.Samples[VerifyExtra][0] != "9:z" {
+		t.Errorf("unexpected extra result: %+v", report)
+	}
+}
+
+func TestVerifyExtraCountedOverLimit(t *testing.T) {
+	s, _ := testVerifyService(2)
+	report := s.verify(testVerifyRule())
+	if report.Err != nil {
+		t.Fatal(report.Err)
+	}
+
+	// 接收端6个标识，MySQL中4行已存在、1行修复写入
+	if !report.ExtraCounted || report.Extra != 1 || len(report.Samples[VerifyExtra]) != 0 {
+		t.Errorf("unexpected extra result: %+v", report)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyPauseSignals SIGUSR1暂停同步，SIGUSR2恢复同步
+func notifyPauseSignals(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+}
+
+func isPauseSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR1
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+)
+
+// notifyPauseSignals Windows不支持SIGUSR1、SIGUSR2，只能通过管理接口暂停、恢复同步
+func notifyPauseSignals(c chan os.Signal) {
+}
+
+func isPauseSignal(sig os.Signal) bool {
+	return false
+}
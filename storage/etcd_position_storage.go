@@ -20,7 +20,9 @@ package storage
 import (
 	"encoding/json"
 
+	"github.com/juju/errors"
 	"github.com/siddontang/go-mysql/mysql"
+	"go.etcd.io/etcd/clientv3"
 
 	"go-mysql-transfer/global"
 	"go-mysql-transfer/util/etcds"
@@ -43,13 +45,34 @@ func (s *etcdPositionStorage) Initialize() error {
 	return nil
 }
 
+// Save 参与选举时以CAS方式写入：当选的选举key仍存在且创建revision等于fencing token时才写入，
+// 会话过期后选举key随租约删除，写入失败
 func (s *etcdPositionStorage) Save(pos mysql.Position) error {
-	data, err := json.Marshal(pos)
+	fence, err := currentFence()
 	if err != nil {
 		return err
 	}
+	if fence == nil {
+		data, err := json.Marshal(pos)
+		if err != nil {
+			return err
+		}
+		return etcds.Save(global.Cfg().ZkPositionDir(), string(data), _etcdOps)
+	}
 
-	return etcds.Save(global.Cfg().ZkPositionDir(), string(data), _etcdOps)
+	data, err := json.Marshal(fencedPosition{Name: pos.Name, Pos: pos.Pos, Token: fence.Token})
+	if err != nil {
+		return err
+	}
+	cmp := clientv3.Compare(clientv3.CreateRevision(fence.Key), "=", fence.Token)
+	ok, err := etcds.SaveIf(global.Cfg().ZkPositionDir(), string(data), cmp, _etcdOps)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Trace(ErrFenced)
+	}
+	return nil
 }
 
 func (s *etcdPositionStorage) Get() (mysql.Position, error) {
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/siddontang/go-mysql/mysql"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+	"go.etcd.io/etcd/embed"
+
+	"go-mysql-transfer/global"
+)
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// startEtcd 启动内嵌的etcd，并将其设为集群存储
+func startEtcd(t *testing.T) *clientv3.Client {
+	dir, err := ioutil.TempDir("", "transfer-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.Logger = "zap"
+	cfg.LogLevel = "error"
+	cfg.LogOutputs = []string{"stderr"}
+	client, peer := freeURL(t), freeURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{client}, []url.URL{client}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal("etcd not ready")
+	}
+
+	conn, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{client.Host},
+		DialTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		e.Close()
+		os.RemoveAll(dir)
+	})
+
+	global.ReplaceConfig(&global.Config{
+		Cluster: &global.Cluster{Name: "fencing", EtcdAddrs: client.Host},
+	})
+	_etcdConn = conn
+	_etcdOps = clientv3.NewKV(conn)
+	return conn
+}
+
+// campaign 以node参与选举并当选，返回会话及fencing token
+func campaign(t *testing.T, conn *clientv3.Client, node string) (*concurrency.Session, *Fence) {
+	session, err := concurrency.NewSession(conn, concurrency.WithTTL(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elc := concurrency.NewElection(session, global.Cfg().ZkElectionDir())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := elc.Campaign(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	return session, &Fence{Key: elc.Key(), Token: elc.Rev()}
+}
+
+func TestEtcdPositionStorageFencing(t *testing.T) {
+	conn := startEtcd(t)
+	EnableFencing()
+	defer _fencing.Store(false)
+	defer SetFence(nil)
+
+	ps := &etcdPositionStorage{}
+	if err := ps.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := mysql.Position{Name: "mysql-bin.000001", Pos: 4}
+	SetFence(nil)
+	if err := ps.Save(first); !IsFenced(err) {
+		t.Fatalf("save without fencing token: %v, want fenced", err)
+	}
+
+	session, fence := campaign(t, conn, "node1")
+	SetFence(fence)
+	if err := ps.Save(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// 会话过期后选举key随租约删除，旧leader不能再写入
+	session.Close()
+	if err := ps.Save(mysql.Position{Name: "mysql-bin.000001", Pos: 100}); !IsFenced(err) {
+		t.Fatalf("save after session closed: %v, want fenced", err)
+	}
+	pos, err := ps.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.Compare(first) != 0 {
+		t.Fatalf("position %v, want %v", pos, first)
+	}
+
+	newSession, newFence := campaign(t, conn, "node2")
+	defer newSession.Close()
+	if newFence.Token <= fence.Token {
+		t.Fatalf("fencing token %d not greater than %d", newFence.Token, fence.Token)
+	}
+	if err := ps.Save(mysql.Position{Name: "mysql-bin.000002", Pos: 4}); !IsFenced(err) {
+		t.Fatalf("save with stale fencing token: %v, want fenced", err)
+	}
+
+	second := mysql.Position{Name: "mysql-bin.000002", Pos: 8}
+	SetFence(newFence)
+	if err := ps.Save(second); err != nil {
+		t.Fatal(err)
+	}
+	if pos, _ = ps.Get(); pos.Compare(second) != 0 {
+		t.Fatalf("position %v, want %v", pos, second)
+	}
+}
+
+func TestEtcdPositionStorageUnfenced(t *testing.T) {
+	startEtcd(t)
+
+	ps := &etcdPositionStorage{}
+	if err := ps.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	want := mysql.Position{Name: "mysql-bin.000003", Pos: 4}
+	if err := ps.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	pos, err := ps.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.Compare(want) != 0 {
+		t.Fatalf("position %v, want %v", pos, want)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"go.etcd.io/etcd/clientv3"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/util/etcds"
+	"go-mysql-transfer/util/logs"
+)
+
+type etcdRuleStorage struct {
+}
+
+func (s *etcdRuleStorage) versionKey(version int) string {
+	return fmt.Sprintf("%s/%010d", global.Cfg().ZkRuleVersionsDir(), version)
+}
+
+func (s *etcdRuleStorage) Initialize() error {
+	return etcds.CreateIfNecessary(global.Cfg().ZkRuleCurrentDir(), "0", _etcdOps)
+}
+
+func (s *etcdRuleStorage) Publish(ruleSet []byte) (int, error) {
+	versions, err := s.Versions()
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	if len(versions) > 0 {
+		version = versions[len(versions)-1] + 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key := s.versionKey(version)
+	resp, err := _etcdOps.Txn(ctx).If(
+		clientv3.Compare(clientv3.ModRevision(key), "=", 0),
+	).Then(
+		clientv3.OpPut(key, string(ruleSet)),
+		clientv3.OpPut(global.Cfg().ZkRuleCurrentDir(), strconv.Itoa(version)),
+	).Commit()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if !resp.Succeeded {
+		return 0, errors.Errorf("rule version %d already published, retry", version)
+	}
+
+	return version, nil
+}
+
+func (s *etcdRuleStorage) Rollback(version int) (int, error) {
+	versions, err := s.Versions()
+	if err != nil {
+		return 0, err
+	}
+
+	if version == 0 {
+		current, _, err := s.currentVersion()
+		if err != nil {
+			return 0, err
+		}
+		if version, err = previousVersion(versions, current); err != nil {
+			return 0, err
+		}
+	}
+	if !containsVersion(versions, version) {
+		return 0, errors.NotFoundf("rule version %d", version)
+	}
+
+	err = etcds.UpdateOrCreate(global.Cfg().ZkRuleCurrentDir(), strconv.Itoa(version), _etcdOps)
+	return version, err
+}
+
+func (s *etcdRuleStorage) currentVersion() (int, int64, error) {
+	data, revision, err := etcds.Get(global.Cfg().ZkRuleCurrentDir(), _etcdOps)
+	if err != nil {
+		return 0, 0, err
+	}
+	version, err := parseVersion(data)
+	return version, revision, err
+}
+
+func (s *etcdRuleStorage) Current() (int, []byte, error) {
+	version, _, err := s.currentVersion()
+	if err != nil || version == 0 {
+		return 0, nil, err
+	}
+
+	data, err := s.Get(version)
+	return version, data, err
+}
+
+func (s *etcdRuleStorage) Get(version int) ([]byte, error) {
+	data, _, err := etcds.Get(s.versionKey(version), _etcdOps)
+	return data, err
+}
+
+func (s *etcdRuleStorage) Versions() ([]int, error) {
+	nodes, err := etcds.List(global.Cfg().ZkRuleVersionsDir()+"/", _etcdOps)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(nodes))
+	for key := range nodes {
+		v, err := strconv.Atoi(key[strings.LastIndex(key, "/")+1:])
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (s *etcdRuleStorage) Watch(stop <-chan struct{}) <-chan int {
+	ch := make(chan int, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	go func() {
+		defer close(ch)
+		for {
+			version, revision, err := s.currentVersion()
+			if err != nil {
+				logs.Errorf("get rule version: %s", errors.ErrorStack(err))
+			} else {
+				ch <- version
+				wc := _etcdConn.Watch(ctx, global.Cfg().ZkRuleCurrentDir(), clientv3.WithRev(revision+1))
+				for resp := range wc {
+					for _, ev := range resp.Events {
+						if ev.Type != clientv3.EventTypePut {
+							continue
+						}
+						if v, err := parseVersion(ev.Kv.Value); err == nil {
+							ch <- v
+						}
+					}
+				}
+			}
+
+			// 被stop取消时退出，否则(如连接中断、revision被压缩)重新监听
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	return ch
+}
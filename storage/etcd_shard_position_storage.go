@@ -0,0 +1,76 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/juju/errors"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/util/etcds"
+)
+
+type etcdShardStore struct {
+}
+
+func (s *etcdShardStore) initialize() error {
+	return nil
+}
+
+func (s *etcdShardStore) get(node string) (*shardPosition, error) {
+	data, _, err := etcds.Get(global.Cfg().ZkShardsDir()+"/"+node, _etcdOps)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entity shardPosition
+	if err := json.Unmarshal(data, &entity); err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+func (s *etcdShardStore) put(node string, p *shardPosition) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return etcds.UpdateOrCreate(global.Cfg().ZkShardsDir()+"/"+node, string(data), _etcdOps)
+}
+
+func (s *etcdShardStore) list() ([]*shardPosition, error) {
+	nodes, err := etcds.List(global.Cfg().ZkShardsDir()+"/", _etcdOps)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*shardPosition, 0, len(nodes))
+	for _, node := range nodes {
+		var entity shardPosition
+		if err := json.Unmarshal(node.Value, &entity); err != nil {
+			return nil, err
+		}
+		list = append(list, &entity)
+	}
+	return list, nil
+}
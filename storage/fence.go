@@ -0,0 +1,72 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package storage
+
+import (
+	"github.com/juju/errors"
+	"go.uber.org/atomic"
+)
+
+// ErrFenced 保存位置时fencing token校验失败，当前节点已不是leader
+var ErrFenced = errors.New("fencing token rejected, the current node is no longer the leader")
+
+// Fence 当选leader时获得的fencing token，集群模式下保存位置时以CAS方式校验，
+// 防止会话过期的旧leader在察觉之前继续写入位置
+type Fence struct {
+	Key   string // etcd为当选的选举key，zk为选举节点
+	Token int64  // etcd为选举key的创建revision，zk为选举节点的czxid，每次当选递增
+}
+
+var (
+	_fencing atomic.Bool
+	_fence   atomic.Value
+)
+
+// EnableFencing 参与选举时开启，之后没有fencing token(非leader)时不能保存位置；
+// 未参与选举的命令(如-position)不校验
+func EnableFencing() {
+	_fencing.Store(true)
+}
+
+// SetFence 当选leader时设置，成为follower时设置为nil
+func SetFence(f *Fence) {
+	_fence.Store(f)
+}
+
+func currentFence() (*Fence, error) {
+	if !_fencing.Load() {
+		return nil, nil
+	}
+	f, _ := _fence.Load().(*Fence)
+	if f == nil {
+		return nil, errors.Trace(ErrFenced)
+	}
+	return f, nil
+}
+
+// IsFenced 错误是否由fencing token校验失败引起
+func IsFenced(err error) bool {
+	return errors.Cause(err) == ErrFenced
+}
+
+// fencedPosition 集群中保存的位置，附带写入时的fencing token
+type fencedPosition struct {
+	Name  string
+	Pos   uint32
+	Token int64 `json:"token,omitempty"`
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package storage
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/juju/errors"
+
+	"go-mysql-transfer/global"
+)
+
+// RuleStorage 集群中的规则集存储，每次发布生成一个递增的版本，current指向当前生效的版本
+type RuleStorage interface {
+	Initialize() error
+	Publish(ruleSet []byte) (int, error)   // 保存为新版本并设为当前版本
+	Rollback(version int) (int, error)     // 将当前版本切换为version，version为0时切换为当前版本的上一个版本
+	Current() (int, []byte, error)         // 当前版本及其内容，未发布过时版本为0
+	Get(version int) ([]byte, error)       // 指定版本的内容
+	Versions() ([]int, error)              // 全部版本，升序
+	Watch(stop <-chan struct{}) <-chan int // 推送当前版本，启动时及每次变化时推送
+}
+
+func NewRuleStorage() RuleStorage {
+	if global.Cfg().IsZk() {
+		return &zkRuleStorage{}
+	}
+	if global.Cfg().IsEtcd() {
+		return &etcdRuleStorage{}
+	}
+	return nil
+}
+
+// previousVersion 返回versions中小于current的最大版本
+func previousVersion(versions []int, current int) (int, error) {
+	sort.Ints(versions)
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i] < current {
+			return versions[i], nil
+		}
+	}
+	return 0, errors.Errorf("no version before %d", current)
+}
+
+func containsVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func parseVersion(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, errors.Annotatef(err, "invalid rule version %q", data)
+	}
+	return v, nil
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package storage
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-mysql/mysql"
+
+	"go-mysql-transfer/global"
+)
+
+// ShardPositionStorage 按表分片时各节点独立保存的binlog位置，同时记录节点负责的表
+type ShardPositionStorage interface {
+	PositionStorage
+	// Assign 替换本节点负责的表，返回应开始同步的位置
+	Assign(tables []string) (mysql.Position, error)
+}
+
+type shardPosition struct {
+	Name     string                   `json:"name"`
+	Pos      uint32                   `json:"pos"`
+	Tables   []string                 `json:"tables"`
+	Seq      int64                    `json:"seq"`                // 分配序号，多个节点记录了同一张表时以序号大的为准
+	Released map[string]*shardHandoff `json:"released,omitempty"` // 本节点已交出、尚未被其他节点接手的表
+}
+
+// shardHandoff 交出一张表时本节点的位置及当时的分配序号，接手的节点从该位置开始同步这张表
+type shardHandoff struct {
+	Name string `json:"name"`
+	Pos  uint32 `json:"pos"`
+	Seq  int64  `json:"seq"`
+}
+
+func (h *shardHandoff) position() mysql.Position {
+	return mysql.Position{Name: h.Name, Pos: h.Pos}
+}
+
+func (p *shardPosition) position() mysql.Position {
+	return mysql.Position{Name: p.Name, Pos: p.Pos}
+}
+
+// shardStore 各节点位置记录的读写
+type shardStore interface {
+	initialize() error
+	get(node string) (*shardPosition, error) // 记录不存在时返回nil
+	put(node string, p *shardPosition) error
+	list() ([]*shardPosition, error)
+}
+
+type shardPositionStorage struct {
+	lock    sync.Mutex
+	node    string
+	store   shardStore
+	global  PositionStorage // 未分片时集群统一保存的位置
+	current *shardPosition
+}
+
+func NewShardPositionStorage(node string) ShardPositionStorage {
+	s := &shardPositionStorage{node: node}
+	if global.Cfg().IsZk() {
+		s.store, s.global = &zkShardStore{}, &zkPositionStorage{}
+	} else {
+		s.store, s.global = &etcdShardStore{}, &etcdPositionStorage{}
+	}
+	return s
+}
+
+func (s *shardPositionStorage) Initialize() error {
+	if err := s.global.Initialize(); err != nil {
+		return err
+	}
+	return s.store.initialize()
+}
+
+func (s *shardPositionStorage) Save(pos mysql.Position) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.current == nil {
+		return errors.Errorf("no table assigned to node %s", s.node)
+	}
+	p := *s.current
+	p.Name, p.Pos = pos.Name, pos.Pos
+	if err := s.store.put(s.node, &p); err != nil {
+		return err
+	}
+	s.current = &p
+	return nil
+}
+
+// Get 本节点保存的位置，尚未分配过表时为未分片时的位置
+func (s *shardPositionStorage) Get() (mysql.Position, error) {
+	p, err := s.store.get(s.node)
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	if p == nil {
+		return s.global.Get()
+	}
+	return p.position(), nil
+}
+
+// Assign 每张表取最后分配到该表的节点记录的位置(含已交出的表)，没有记录时取未分片时的位置，
+// 从其中最小的位置开始同步，其他表已同步过的事件会重复写入接收端
+func (s *shardPositionStorage) Assign(tables []string) (mysql.Position, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records, err := s.store.list()
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	fallback, err := s.global.Get()
+	if err != nil {
+		return mysql.Position{}, err
+	}
+	prev := s.current
+	if prev == nil {
+		if prev, err = s.store.get(s.node); err != nil {
+			return mysql.Position{}, err
+		}
+	}
+
+	pos, seq := shardStart(records, tables, fallback)
+	p := &shardPosition{
+		Name:     pos.Name,
+		Pos:      pos.Pos,
+		Tables:   tables,
+		Seq:      seq,
+		Released: shardReleased(prev, records, tables),
+	}
+	if err := s.store.put(s.node, p); err != nil {
+		return mysql.Position{}, err
+	}
+	s.current = p
+	return pos, nil
+}
+
+// shardReleased 本节点交出的表及交出时的位置：保留上次记录中尚未被其他节点以更大序号接手的表，
+// 加上本次不再负责的表；节点各自定时重新分片，先交出的节点需保留位置，供后接手的节点使用
+func shardReleased(prev *shardPosition, records []*shardPosition, tables []string) map[string]*shardHandoff {
+	if prev == nil {
+		return nil
+	}
+	released := make(map[string]*shardHandoff)
+	for table, h := range prev.Released {
+		released[table] = h
+	}
+	for _, table := range prev.Tables {
+		released[table] = &shardHandoff{Name: prev.Name, Pos: prev.Pos, Seq: prev.Seq}
+	}
+	for _, table := range tables {
+		delete(released, table)
+	}
+	for _, r := range records {
+		for _, table := range r.Tables {
+			if h, ok := released[table]; ok && r.Seq > h.Seq {
+				delete(released, table)
+			}
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+	return released
+}
+
+// shardStart 计算分配tables后的起始位置及新的分配序号
+func shardStart(records []*shardPosition, tables []string, fallback mysql.Position) (mysql.Position, int64) {
+	type owner struct {
+		pos mysql.Position
+		seq int64
+	}
+
+	var seq int64
+	owners := make(map[string]owner)
+	claim := func(table string, pos mysql.Position, seq int64) {
+		o, ok := owners[table]
+		if !ok || seq > o.seq || (seq == o.seq && pos.Compare(o.pos) < 0) {
+			owners[table] = owner{pos: pos, seq: seq}
+		}
+	}
+	for _, r := range records {
+		if r.Seq > seq {
+			seq = r.Seq
+		}
+		for _, table := range r.Tables {
+			claim(table, r.position(), r.Seq)
+		}
+		for table, h := range r.Released {
+			claim(table, h.position(), h.Seq)
+		}
+	}
+
+	var start *mysql.Position
+	for _, table := range tables {
+		pos := fallback
+		if o, ok := owners[table]; ok {
+			pos = o.pos
+		}
+		if start == nil || pos.Compare(*start) < 0 {
+			start = &pos
+		}
+	}
+	if start == nil {
+		return fallback, seq + 1
+	}
+	return *start, seq + 1
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/siddontang/go-mysql/mysql"
+)
+
+func TestShardStart(t *testing.T) {
+	fallback := mysql.Position{Name: "mysql-bin.000001", Pos: 4}
+	cases := []struct {
+		name    string
+		records []*shardPosition
+		tables  []string
+		want    mysql.Position
+		seq     int64
+	}{
+		{
+			// t1已重新分配给n2，n1的旧记录中仍有t1，但位置以最新的分配为准
+			name: "owner changed",
+			records: []*shardPosition{
+				{Name: "mysql-bin.000001", Pos: 100, Tables: []string{"t1", "t2"}, Seq: 1},
+				{Name: "mysql-bin.000002", Pos: 500, Tables: []string{"t1"}, Seq: 2},
+			},
+			tables: []string{"t1"},
+			want:   mysql.Position{Name: "mysql-bin.000002", Pos: 500},
+			seq:    3,
+		},
+		{
+			// n1先重新分片交出了t1，接手的n2尚无t1的记录，从n1交出时的位置开始
+			name: "previous owner already re-assigned",
+			records: []*shardPosition{
+				{Name: "mysql-bin.000003", Pos: 200, Tables: []string{"t2"}, Seq: 3,
+					Released: map[string]*shardHandoff{"t1": {Name: "mysql-bin.000002", Pos: 700, Seq: 1}}},
+				{Name: "mysql-bin.000002", Pos: 900, Tables: []string{"t3"}, Seq: 2},
+			},
+			tables: []string{"t1", "t3"},
+			want:   mysql.Position{Name: "mysql-bin.000002", Pos: 700},
+			seq:    4,
+		},
+		{
+			// 交出后已被其他节点以更大的序号接手，以接手节点的位置为准
+			name: "released table taken over",
+			records: []*shardPosition{
+				{Name: "mysql-bin.000003", Pos: 200, Tables: []string{"t2"}, Seq: 3,
+					Released: map[string]*shardHandoff{"t1": {Name: "mysql-bin.000002", Pos: 700, Seq: 1}}},
+				{Name: "mysql-bin.000004", Pos: 100, Tables: []string{"t1"}, Seq: 4},
+			},
+			tables: []string{"t1"},
+			want:   mysql.Position{Name: "mysql-bin.000004", Pos: 100},
+			seq:    5,
+		},
+		{
+			name: "min over tables",
+			records: []*shardPosition{
+				{Name: "mysql-bin.000001", Pos: 900, Tables: []string{"t1", "t2"}, Seq: 1},
+				{Name: "mysql-bin.000002", Pos: 500, Tables: []string{"t1"}, Seq: 2},
+			},
+			tables: []string{"t1", "t2"},
+			want:   mysql.Position{Name: "mysql-bin.000001", Pos: 900},
+			seq:    3,
+		},
+		{
+			name: "equal seq",
+			records: []*shardPosition{
+				{Name: "mysql-bin.000002", Pos: 500, Tables: []string{"t1"}, Seq: 2},
+				{Name: "mysql-bin.000002", Pos: 300, Tables: []string{"t1"}, Seq: 2},
+			},
+			tables: []string{"t1"},
+			want:   mysql.Position{Name: "mysql-bin.000002", Pos: 300},
+			seq:    3,
+		},
+		{
+			name: "fallback",
+			records: []*shardPosition{
+				{Name: "mysql-bin.000002", Pos: 500, Tables: []string{"t1"}, Seq: 1},
+			},
+			tables: []string{"t1", "t3"},
+			want:   fallback,
+			seq:    2,
+		},
+		{
+			name:   "no records",
+			tables: []string{"t1"},
+			want:   fallback,
+			seq:    1,
+		},
+		{
+			name: "empty assignment",
+			records: []*shardPosition{
+				{Name: "mysql-bin.000002", Pos: 500, Tables: []string{"t1"}, Seq: 4},
+			},
+			tables: []string{},
+			want:   fallback,
+			seq:    5,
+		},
+	}
+
+	for _, c := range cases {
+		pos, seq := shardStart(c.records, c.tables, fallback)
+		if pos.Compare(c.want) != 0 || seq != c.seq {
+			t.Errorf("%s: want %s seq %d, got %s seq %d", c.name, c.want, c.seq, pos, seq)
+		}
+	}
+}
+
+func TestShardReleased(t *testing.T) {
+	prev := &shardPosition{
+		Name: "mysql-bin.000003", Pos: 200, Tables: []string{"t1", "t2"}, Seq: 3,
+		Released: map[string]*shardHandoff{
+			"t3": {Name: "mysql-bin.000002", Pos: 700, Seq: 1},
+			"t4": {Name: "mysql-bin.000002", Pos: 700, Seq: 1},
+		},
+	}
+	records := []*shardPosition{
+		prev,
+		{Name: "mysql-bin.000004", Pos: 100, Tables: []string{"t4"}, Seq: 2}, // t4已被接手
+	}
+
+	released := shardReleased(prev, records, []string{"t2"})
+	if len(released) != 2 || released["t4"] != nil {
+		t.Fatalf("unexpected released tables: %v", released)
+	}
+	if h := released["t1"]; h == nil || h.position().Compare(prev.position()) != 0 || h.Seq != 3 {
+		t.Errorf("t1 should be released at the previous position: %+v", h)
+	}
+	if h := released["t3"]; h == nil || h.Pos != 700 {
+		t.Errorf("t3 should keep its handoff position: %+v", h)
+	}
+	if shardReleased(nil, records, []string{"t1"}) != nil {
+		t.Error("no previous record, nothing released")
+	}
+}
@@ -118,6 +118,13 @@ func initZk() error {
 		return err
 	}
 
+	if global.Pipeline() != "" {
+		err = zookeepers.CreateDirIfNecessary(global.Cfg().ZkRootDir()+"/"+global.Cfg().Cluster.Name, conn)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = zookeepers.CreateDirIfNecessary(global.Cfg().ZkClusterDir(), conn)
 	if err != nil {
 		return err
@@ -20,6 +20,8 @@ package storage
 import (
 	"encoding/json"
 
+	"github.com/juju/errors"
+	"github.com/samuel/go-zookeeper/zk"
 	"github.com/siddontang/go-mysql/mysql"
 
 	"go-mysql-transfer/global"
@@ -45,6 +47,14 @@ func (s *zkPositionStorage) Initialize() error {
 }
 
 func (s *zkPositionStorage) Save(pos mysql.Position) error {
+	fence, err := currentFence()
+	if err != nil {
+		return err
+	}
+	if fence != nil {
+		return s.fencedSave(pos, fence)
+	}
+
 	_, stat, err := _zkConn.Get(global.Cfg().ZkPositionDir())
 	if err != nil {
 		return err
@@ -60,6 +70,48 @@ func (s *zkPositionStorage) Save(pos mysql.Position) error {
 	return err
 }
 
+// fencedSave 选举节点的czxid等于fencing token、且位置未被更新的token写入过时，
+// 在同一事务中校验选举节点版本并按版本写入位置
+func (s *zkPositionStorage) fencedSave(pos mysql.Position, fence *Fence) error {
+	_, elected, err := _zkConn.Get(fence.Key)
+	if err == zk.ErrNoNode {
+		return errors.Trace(ErrFenced)
+	}
+	if err != nil {
+		return err
+	}
+	if elected.Czxid != fence.Token {
+		return errors.Trace(ErrFenced)
+	}
+
+	data, stat, err := _zkConn.Get(global.Cfg().ZkPositionDir())
+	if err != nil {
+		return err
+	}
+	var current fencedPosition
+	if json.Unmarshal(data, &current) == nil && current.Token > fence.Token {
+		return errors.Trace(ErrFenced)
+	}
+
+	data, err = json.Marshal(fencedPosition{Name: pos.Name, Pos: pos.Pos, Token: fence.Token})
+	if err != nil {
+		return err
+	}
+	res, err := _zkConn.Multi(
+		&zk.CheckVersionRequest{Path: fence.Key, Version: elected.Version},
+		&zk.SetDataRequest{Path: global.Cfg().ZkPositionDir(), Data: data, Version: stat.Version},
+	)
+	for _, r := range res {
+		if r.Error != nil && err == nil {
+			err = r.Error
+		}
+	}
+	if err == zk.ErrNoNode || err == zk.ErrBadVersion {
+		return errors.Trace(ErrFenced)
+	}
+	return err
+}
+
 func (s *zkPositionStorage) Get() (mysql.Position, error) {
 	var entity mysql.Position
 
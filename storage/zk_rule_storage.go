@@ -0,0 +1,171 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samuel/go-zookeeper/zk"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/util/logs"
+	"go-mysql-transfer/util/zookeepers"
+)
+
+// 版本节点为顺序节点，名称如v0000000001
+const _zkRuleVersionPrefix = "v"
+
+type zkRuleStorage struct {
+}
+
+func (s *zkRuleStorage) versionDir(version int) string {
+	return fmt.Sprintf("%s/%s%010d", global.Cfg().ZkRuleVersionsDir(), _zkRuleVersionPrefix, version)
+}
+
+func (s *zkRuleStorage) Initialize() error {
+	err := zookeepers.CreateDirIfNecessary(global.Cfg().ZkRulesDir(), _zkConn)
+	if err != nil {
+		return err
+	}
+
+	err = zookeepers.CreateDirIfNecessary(global.Cfg().ZkRuleVersionsDir(), _zkConn)
+	if err != nil {
+		return err
+	}
+
+	return zookeepers.CreateDirWithDataIfNecessary(global.Cfg().ZkRuleCurrentDir(), []byte("0"), _zkConn)
+}
+
+func (s *zkRuleStorage) Publish(ruleSet []byte) (int, error) {
+	prefix := global.Cfg().ZkRuleVersionsDir() + "/" + _zkRuleVersionPrefix
+	path, err := _zkConn.Create(prefix, ruleSet, zk.FlagSequence, zk.WorldACL(zk.PermAll))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(path, prefix))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	_, err = _zkConn.Set(global.Cfg().ZkRuleCurrentDir(), []byte(strconv.Itoa(version)), -1)
+	return version, errors.Trace(err)
+}
+
+func (s *zkRuleStorage) Rollback(version int) (int, error) {
+	versions, err := s.Versions()
+	if err != nil {
+		return 0, err
+	}
+
+	if version == 0 {
+		data, _, err := _zkConn.Get(global.Cfg().ZkRuleCurrentDir())
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		current, err := parseVersion(data)
+		if err != nil {
+			return 0, err
+		}
+		if version, err = previousVersion(versions, current); err != nil {
+			return 0, err
+		}
+	}
+	if !containsVersion(versions, version) {
+		return 0, errors.NotFoundf("rule version %d", version)
+	}
+
+	_, err = _zkConn.Set(global.Cfg().ZkRuleCurrentDir(), []byte(strconv.Itoa(version)), -1)
+	return version, errors.Trace(err)
+}
+
+func (s *zkRuleStorage) Current() (int, []byte, error) {
+	data, _, err := _zkConn.Get(global.Cfg().ZkRuleCurrentDir())
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+	version, err := parseVersion(data)
+	if err != nil || version == 0 {
+		return 0, nil, err
+	}
+
+	ruleSet, err := s.Get(version)
+	return version, ruleSet, err
+}
+
+func (s *zkRuleStorage) Get(version int) ([]byte, error) {
+	data, _, err := _zkConn.Get(s.versionDir(version))
+	if err == zk.ErrNoNode {
+		return nil, errors.NotFoundf("rule version %d", version)
+	}
+	return data, errors.Trace(err)
+}
+
+func (s *zkRuleStorage) Versions() ([]int, error) {
+	children, _, err := _zkConn.Children(global.Cfg().ZkRuleVersionsDir())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	versions := make([]int, 0, len(children))
+	for _, child := range children {
+		v, err := strconv.Atoi(strings.TrimPrefix(child, _zkRuleVersionPrefix))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (s *zkRuleStorage) Watch(stop <-chan struct{}) <-chan int {
+	ch := make(chan int, 1)
+	go func() {
+		defer close(ch)
+		for {
+			data, _, event, err := _zkConn.GetW(global.Cfg().ZkRuleCurrentDir())
+			if err != nil {
+				logs.Errorf("watch rule version: %s", err.Error())
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+
+			if version, err := parseVersion(data); err == nil {
+				ch <- version
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-event:
+			}
+		}
+	}()
+
+	return ch
+}
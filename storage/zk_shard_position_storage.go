@@ -0,0 +1,83 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/samuel/go-zookeeper/zk"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/util/zookeepers"
+)
+
+type zkShardStore struct {
+}
+
+func (s *zkShardStore) initialize() error {
+	return zookeepers.CreateDirIfNecessary(global.Cfg().ZkShardsDir(), _zkConn)
+}
+
+func (s *zkShardStore) get(node string) (*shardPosition, error) {
+	data, _, err := _zkConn.Get(global.Cfg().ZkShardsDir() + "/" + node)
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entity shardPosition
+	if err := json.Unmarshal(data, &entity); err != nil {
+		return nil, err
+	}
+	return &entity, nil
+}
+
+func (s *zkShardStore) put(node string, p *shardPosition) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	dir := global.Cfg().ZkShardsDir() + "/" + node
+	_, err = _zkConn.Set(dir, data, -1)
+	if err == zk.ErrNoNode {
+		_, err = _zkConn.Create(dir, data, 0, zk.WorldACL(zk.PermAll))
+	}
+	return err
+}
+
+func (s *zkShardStore) list() ([]*shardPosition, error) {
+	nodes, _, err := _zkConn.Children(global.Cfg().ZkShardsDir())
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*shardPosition, 0, len(nodes))
+	for _, node := range nodes {
+		p, err := s.get(node)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			list = append(list, p)
+		}
+	}
+	return list, nil
+}
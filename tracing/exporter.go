@@ -0,0 +1,308 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+
+	"go-mysql-transfer/util/logs"
+)
+
+const (
+	_queueSize     = 4096
+	_batchSize     = 512
+	_flushInterval = 2 * time.Second
+	_sendTimeout   = 10 * time.Second
+
+	_scopeName = "go-mysql-transfer"
+)
+
+type Options struct {
+	Endpoint    string            // OTLP/HTTP traces接收地址，如http://127.0.0.1:4318/v1/traces
+	Headers     map[string]string // 附加的请求头，如认证信息
+	SampleRatio float64           // 新trace的采样比例，取值(0,1]
+	ServiceName string
+	Attributes  map[string]string // 资源属性
+}
+
+var (
+	_options  Options
+	_client   *http.Client
+	_resource []otlpAttribute
+	_queue    chan *Span
+	_stop     chan struct{}
+	_wg       sync.WaitGroup
+)
+
+// Initialize 开启链路追踪，启动导出协程
+func Initialize(options Options) error {
+	if options.Endpoint == "" {
+		return errors.New("tracing endpoint cannot be empty")
+	}
+	if options.SampleRatio <= 0 || options.SampleRatio > 1 {
+		return errors.Errorf("tracing sample ratio must be in (0,1], got %v", options.SampleRatio)
+	}
+
+	_options = options
+	_ratio = options.SampleRatio
+	_client = &http.Client{Timeout: _sendTimeout}
+	_resource = []otlpAttribute{stringAttribute("service.name", options.ServiceName)}
+	keys := make([]string, 0, len(options.Attributes))
+	for k := range options.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_resource = append(_resource, stringAttribute(k, options.Attributes[k]))
+	}
+	_queue = make(chan *Span, _queueSize)
+	_stop = make(chan struct{})
+
+	_wg.Add(1)
+	go run()
+	_enabled.Store(true)
+	return nil
+}
+
+// Close 停止追踪，导出剩余的span
+func Close() {
+	if !_enabled.Load() {
+		return
+	}
+	_enabled.Store(false)
+	close(_stop)
+	_wg.Wait()
+}
+
+// export 队列满时丢弃，不阻塞同步
+func export(s *Span) {
+	select {
+	case _queue <- s:
+	default:
+	}
+}
+
+func run() {
+	defer _wg.Done()
+	ticker := time.NewTicker(_flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, _batchSize)
+	for {
+		select {
+		case s := <-_queue:
+			batch = append(batch, s)
+			if len(batch) >= _batchSize {
+				send(batch)
+				batch = batch[0:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				send(batch)
+				batch = batch[0:0]
+			}
+		case <-_stop:
+			for drained := false; !drained; {
+				select {
+				case s := <-_queue:
+					batch = append(batch, s)
+				default:
+					drained = true
+				}
+			}
+			if len(batch) > 0 {
+				send(batch)
+			}
+			return
+		}
+	}
+}
+
+func send(batch []*Span) {
+	data, err := json.Marshal(buildRequest(batch))
+	if err != nil {
+		logs.Errorf("tracing marshal spans: %s", err.Error())
+		return
+	}
+
+	request, err := http.NewRequest(http.MethodPost, _options.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		logs.Errorf("tracing export: %s", err.Error())
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range _options.Headers {
+		request.Header.Set(k, v)
+	}
+
+	response, err := _client.Do(request)
+	if err != nil {
+		logs.Errorf("tracing export %d spans: %s", len(batch), err.Error())
+		return
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		logs.Errorf("tracing export %d spans: collector respond status %d", len(batch), response.StatusCode)
+	}
+}
+
+// OTLP/HTTP JSON编码，见opentelemetry-proto的trace.proto；traceId、spanId为十六进制字符串
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Links             []otlpLink      `json:"links,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpLink struct {
+	TraceId string `json:"traceId"`
+	SpanId  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 1 OK，2 ERROR
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func buildRequest(batch []*Span) *otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.lock.Lock()
+		v := otlpSpan{
+			TraceId:           hex.EncodeToString(s.ctx.TraceID[:]),
+			SpanId:            hex.EncodeToString(s.ctx.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent.IsValid() {
+			v.ParentSpanId = hex.EncodeToString(s.parent.SpanID[:])
+		}
+		for _, attr := range s.attrs {
+			v.Attributes = append(v.Attributes, toAttribute(attr))
+		}
+		for _, link := range s.links {
+			v.Links = append(v.Links, otlpLink{
+				TraceId: hex.EncodeToString(link.TraceID[:]),
+				SpanId:  hex.EncodeToString(link.SpanID[:]),
+			})
+		}
+		if s.err != "" {
+			v.Status = &otlpStatus{Code: 2, Message: s.err}
+		}
+		s.lock.Unlock()
+		spans = append(spans, v)
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: _resource},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: _scopeName},
+				Spans: spans,
+			}},
+		}},
+	}
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func toAttribute(attr attribute) otlpAttribute {
+	var value otlpValue
+	switch v := attr.value.(type) {
+	case string:
+		value.StringValue = &v
+	case bool:
+		value.BoolValue = &v
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		value.IntValue = &s
+	case uint32:
+		s := strconv.FormatUint(uint64(v), 10)
+		value.IntValue = &s
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		value.IntValue = &s
+	case float64:
+		value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		value.StringValue = &s
+	}
+	return otlpAttribute{Key: attr.key, Value: value}
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package tracing
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// 链路追踪，span按OpenTelemetry的数据模型记录，通过OTLP/HTTP(JSON)导出到collector；
+// 未开启时Start返回nil，Span的方法均可在nil上调用
+
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+	KindProducer = 4
+	KindConsumer = 5
+
+	// TraceParentHeader W3C Trace Context传播使用的请求头、消息头
+	TraceParentHeader = "traceparent"
+)
+
+var (
+	_enabled atomic.Bool
+	_ratio   float64
+
+	_random     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	_randomLock sync.Mutex
+)
+
+// SpanContext 跨进程、跨协程传递的追踪上下文
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid 是否为有效的上下文，未开启追踪时为空
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// TraceParent W3C traceparent格式，无效时返回空字符串
+func (c SpanContext) TraceParent() string {
+	if !c.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(c.TraceID[:]) + "-" + hex.EncodeToString(c.SpanID[:]) + "-" + flags
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+type Span struct {
+	name   string
+	kind   int
+	ctx    SpanContext
+	parent SpanContext
+	start  time.Time
+	end    time.Time
+	attrs  []attribute
+	links  []SpanContext
+	err    string
+	lock   sync.Mutex
+	ended  bool
+}
+
+// Enabled 是否开启了链路追踪
+func Enabled() bool {
+	return _enabled.Load()
+}
+
+// Start 开始一个span，parent无效时开始一个新的trace
+func Start(name string, kind int, parent SpanContext) *Span {
+	return StartAt(name, kind, parent, time.Now())
+}
+
+// StartAt 以指定的开始时间开始一个span
+func StartAt(name string, kind int, parent SpanContext, start time.Time) *Span {
+	if !_enabled.Load() {
+		return nil
+	}
+
+	s := &Span{
+		name:   name,
+		kind:   kind,
+		parent: parent,
+		start:  start,
+	}
+	_randomLock.Lock()
+	if parent.IsValid() {
+		s.ctx.TraceID = parent.TraceID
+		s.ctx.Sampled = parent.Sampled
+	} else {
+		_random.Read(s.ctx.TraceID[:])
+		s.ctx.Sampled = _random.Float64() < _ratio
+	}
+	_random.Read(s.ctx.SpanID[:])
+	_randomLock.Unlock()
+	return s
+}
+
+// Context span的上下文，用于创建子span或传播到下游
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttribute 设置属性，value支持string、bool、整数及浮点数，其他类型按字符串记录
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil || !s.ctx.Sampled {
+		return
+	}
+	s.lock.Lock()
+	s.attrs = append(s.attrs, attribute{key: key, value: value})
+	s.lock.Unlock()
+}
+
+// AddLink 关联其他trace中的span，如批量处理关联其包含的binlog事件
+func (s *Span) AddLink(c SpanContext) {
+	if s == nil || !s.ctx.Sampled || !c.IsValid() {
+		return
+	}
+	s.lock.Lock()
+	s.links = append(s.links, c)
+	s.lock.Unlock()
+}
+
+// SetError 标记span失败，err为nil时忽略
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	s.err = err.Error()
+	s.lock.Unlock()
+}
+
+// End 结束span并提交导出，重复调用时忽略
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.lock.Unlock()
+
+	if s.ctx.Sampled {
+		export(s)
+	}
+}
@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// collector 本地collector替身，记录收到的OTLP/HTTP请求
+type collector struct {
+	lock     sync.Mutex
+	requests []otlpRequest
+	headers  []http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.lock.Lock()
+	c.requests = append(c.requests, request)
+	c.headers = append(c.headers, r.Header)
+	c.lock.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *collector) spans() map[string]otlpSpan {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	spans := make(map[string]otlpSpan)
+	for _, request := range c.requests {
+		for _, rs := range request.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func TestDisabled(t *testing.T) {
+	s := Start("event", KindConsumer, SpanContext{})
+	if s != nil {
+		t.Fatal("span should be nil when tracing disabled")
+	}
+	s.SetAttribute("k", "v")
+	s.SetError(errors.New("failed"))
+	s.End()
+	if s.Context().IsValid() || s.Context().TraceParent() != "" {
+		t.Fatal("nil span should have an empty context")
+	}
+}
+
+func TestExport(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	err := Initialize(Options{
+		Endpoint:    server.URL + "/v1/traces",
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		SampleRatio: 1,
+		ServiceName: "transfer-test",
+		Attributes:  map[string]string{"transfer.pipeline": "orders"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := Start("binlog.event", KindConsumer, SpanContext{})
+	event.SetAttribute("db.table", "eseap:t_user")
+	event.SetAttribute("rows", 3)
+	event.End()
+
+	batch := Start("transfer.batch", KindInternal, SpanContext{})
+	batch.AddLink(event.Context())
+	consume := Start("endpoint.consume", KindProducer, batch.Context())
+	consume.SetError(errors.New("destination down"))
+	consume.End()
+	batch.End()
+	Close()
+
+	traceParent := event.Context().TraceParent()
+	if !strings.HasPrefix(traceParent, "00-") || !strings.HasSuffix(traceParent, "-01") || len(traceParent) != 55 {
+		t.Fatalf("unexpected traceparent %s", traceParent)
+	}
+
+	spans := c.spans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	if c.headers[0].Get("Authorization") != "Bearer token" {
+		t.Fatal("collector headers not sent")
+	}
+	resource := c.requests[0].ResourceSpans[0].Resource.Attributes
+	if len(resource) != 2 || *resource[0].Value.StringValue != "transfer-test" {
+		t.Fatalf("unexpected resource %+v", resource)
+	}
+
+	e := spans["binlog.event"]
+	if e.ParentSpanId != "" || len(e.Attributes) != 2 || *e.Attributes[1].Value.IntValue != "3" {
+		t.Fatalf("unexpected event span %+v", e)
+	}
+	b := spans["transfer.batch"]
+	if len(b.Links) != 1 || b.Links[0].TraceId != e.TraceId || b.Links[0].SpanId != e.SpanId {
+		t.Fatalf("batch should link to the event, got %+v", b.Links)
+	}
+	cs := spans["endpoint.consume"]
+	if cs.TraceId != b.TraceId || cs.ParentSpanId != b.SpanId {
+		t.Fatal("consume should be a child of the batch")
+	}
+	if cs.Status == nil || cs.Status.Code != 2 || cs.Status.Message != "destination down" {
+		t.Fatalf("unexpected consume status %+v", cs.Status)
+	}
+}
@@ -139,6 +139,21 @@ func Save(key, val string, ops clientv3.KV, opts ...clientv3.OpOption) error {
 	return nil
 }
 
+// SaveIf puts a key/value only if the comparison succeeds, returns whether the put is applied
+func SaveIf(key, val string, cmp clientv3.Cmp, ops clientv3.KV, opts ...clientv3.OpOption) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), _etcdOpsTimeout)
+	defer cancel()
+
+	resp, err := ops.Txn(ctx).If(cmp).Then(
+		clientv3.OpPut(key, val, opts...),
+	).Commit()
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	return resp.Succeeded, nil
+}
+
 // UpdateOrCreate updates a key/value, if the key does not exist then create, or update
 func UpdateOrCreate(key, val string, ops clientv3.KV, opts ...clientv3.OpOption) error {
 	ctx, cancel := context.WithTimeout(context.Background(), _etcdOpsTimeout)
@@ -47,3 +47,13 @@ func MkdirIfNecessary(path string) error {
 	}
 	return nil
 }
+
+// 将目录项(新建、重命名的文件)刷入磁盘
+func SyncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2020-2021 the original author(https://github.com/wj596)
+ *
+ * <p>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ * </p>
+ */
+package hashring
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// Ring 一致性哈希环，每个节点映射为replicas个虚拟节点，
+// 节点增减时只有相邻区间的key改变归属
+type Ring struct {
+	replicas int
+	hashes   []uint32
+	nodes    map[uint32]string
+}
+
+func New(replicas int, nodes ...string) *Ring {
+	r := &Ring{
+		replicas: replicas,
+		nodes:    make(map[uint32]string),
+	}
+	for _, node := range nodes {
+		r.Add(node)
+	}
+	return r
+}
+
+func (r *Ring) Add(node string) {
+	for i := 0; i < r.replicas; i++ {
+		h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + node))
+		if _, ok := r.nodes[h]; ok { // 哈希冲突时保留先加入的节点
+			continue
+		}
+		r.nodes[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+}
+
+// Get key所属的节点，环为空时返回空字符串
+func (r *Ring) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
@@ -0,0 +1,39 @@
+package hashring
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRingGet(t *testing.T) {
+	if New(10).Get("a") != "" {
+		t.Fatal("empty ring should return empty node")
+	}
+
+	nodes := []string{"10.0.0.1:8060", "10.0.0.2:8060", "10.0.0.3:8060"}
+	r := New(100, nodes...)
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := "db:t_" + strconv.Itoa(i)
+		owners[key] = r.Get(key)
+		counts[owners[key]]++
+	}
+	for _, node := range nodes {
+		if counts[node] == 0 {
+			t.Errorf("node %s owns no key", node)
+		}
+	}
+
+	// 移除一个节点后，只有该节点的key改变归属
+	r = New(100, nodes[0], nodes[2])
+	for key, owner := range owners {
+		got := r.Get(key)
+		if owner != nodes[1] && got != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, got)
+		}
+		if got == nodes[1] {
+			t.Fatalf("key %s still owned by removed node", key)
+		}
+	}
+}
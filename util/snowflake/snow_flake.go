@@ -1,6 +1,7 @@
 package snowflake
 
 import (
+	"github.com/juju/errors"
 	"github.com/sony/sonyflake"
 
 	"go-mysql-transfer/util/logs"
@@ -23,9 +24,12 @@ func InitSnowflake(machineId uint16) {
 }
 
 func NextId() (uint64, error) {
+	if _sf == nil {
+		return 0, errors.New("snowflake not initialized")
+	}
 	id, err := _sf.NextID()
 	if err != nil {
 		logs.Errorf("snowflake NextId ：%s", err.Error())
 	}
-	return id, nil
+	return id, err
 }
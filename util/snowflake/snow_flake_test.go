@@ -0,0 +1,25 @@
+package snowflake
+
+import (
+	"testing"
+
+	"github.com/sony/sonyflake"
+)
+
+func TestNextIdMachineId(t *testing.T) {
+	if _, err := NextId(); err == nil {
+		t.Fatal("expected error before InitSnowflake")
+	}
+
+	InitSnowflake(7)
+	// 重复初始化不改变节点标识
+	InitSnowflake(9)
+
+	id, err := NextId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if machineId := sonyflake.Decompose(id)["machine-id"]; machineId != 7 {
+		t.Errorf("want machine id 7, got %d", machineId)
+	}
+}
@@ -0,0 +1,167 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, path, typ string, data []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// selfSignedClientCert 生成自签名的客户端证书，返回证书及私钥文件路径
+func selfSignedClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "transfer-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return cert, certFile, keyFile
+}
+
+func get(cfg *tls.Config, url string) error {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestNewConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	cases := []struct {
+		name       string
+		ca         string
+		serverName string
+		skipVerify bool
+		ok         bool
+	}{
+		{name: "system roots", ok: false},
+		{name: "custom ca", ca: caFile, ok: true},
+		{name: "server name in certificate", ca: caFile, serverName: "example.com", ok: true},
+		{name: "server name mismatch", ca: caFile, serverName: "mysql.internal", ok: false},
+		{name: "skip verify", skipVerify: true, ok: true},
+	}
+	for _, c := range cases {
+		cfg, err := NewConfig(c.ca, "", "", c.serverName, c.skipVerify)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		err = get(cfg, server.URL)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected handshake failure", c.name)
+		}
+	}
+}
+
+func TestNewConfigClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCert, certFile, keyFile := selfSignedClientCert(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	cfg, err := NewConfig(caFile, certFile, keyFile, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(cfg, server.URL); err != nil {
+		t.Errorf("mutual tls failed: %v", err)
+	}
+
+	cfg, err = NewConfig(caFile, "", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(cfg, server.URL); err == nil {
+		t.Error("expected failure without client certificate")
+	}
+}
+
+func TestNewConfigInvalidFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bad := filepath.Join(dir, "bad.pem")
+	if err := ioutil.WriteFile(bad, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewConfig(bad, "", "", "", false); err == nil {
+		t.Error("expected error for invalid ca")
+	}
+	if _, err := NewConfig("", bad, bad, "", false); err == nil {
+		t.Error("expected error for invalid key pair")
+	}
+}
@@ -0,0 +1,247 @@
+package web
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/siddontang/go-mysql/mysql"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/metrics"
+	"go-mysql-transfer/service"
+	"go-mysql-transfer/util/dates"
+)
+
+// registerApi 管理接口，请求需携带 Authorization: Bearer {web_admin_token}
+func registerApi(g *gin.Engine) {
+	token := global.Cfg().WebAdminToken
+	if token == "" {
+		log.Println("web_admin_token not set, management api disabled")
+		return
+	}
+
+	api := g.Group("/api", tokenAuth(token))
+	api.GET("/status", statusFunc)
+	api.GET("/position", positionFunc)
+	api.PUT("/position", setPositionFunc)
+	api.GET("/rules", rulesFunc)
+	api.POST("/rules/reload", reloadRulesFunc)
+	api.GET("/rules/versions", ruleVersionsFunc)
+	api.GET("/rules/versions/:version", ruleSetFunc)
+	api.POST("/rules/publish", publishRulesFunc)
+	api.POST("/rules/rollback", rollbackRulesFunc)
+	api.POST("/dump/pause", pauseFunc)
+	api.POST("/dump/resume", resumeFunc)
+	api.GET("/stock", stockStatusFunc)
+	api.POST("/stock", stockFunc)
+}
+
+func tokenAuth(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		actual := []byte(c.GetHeader("Authorization"))
+		if subtle.ConstantTimeCompare(actual, expected) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func apiError(c *gin.Context, code int, err error) {
+	c.JSON(code, gin.H{"error": err.Error()})
+}
+
+func statusFunc(c *gin.Context) {
+	pos, err := service.TransferServiceIns().Position()
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	h := gin.H{
+		"mysql":        global.Cfg().Addr,
+		"destName":     global.Cfg().DestStdName(),
+		"destAddr":     global.Cfg().DestAddr(),
+		"destState":    service.TransferServiceIns().EndpointEnable(),
+		"running":      service.TransferServiceIns().CanalEnable(),
+		"paused":       service.TransferServiceIns().Paused(),
+		"binName":      pos.Name,
+		"binPos":       pos.Pos,
+		"delay":        metrics.TransferDelay(),
+		"ruleVersion":  service.TransferServiceIns().RuleVersion(),
+		"bootTime":     dates.Layout(global.BootTime(), dates.DayTimeSecondFormatter),
+		"insertAmount": metrics.InsertAmount(),
+		"updateAmount": metrics.UpdateAmount(),
+		"deleteAmount": metrics.DeleteAmount(),
+		"isCluster":    global.Cfg().IsCluster(),
+	}
+	if global.Cfg().IsCluster() {
+		h["node"] = global.CurrentNode()
+		h["isLeader"] = global.IsLeader()
+		h["leader"] = global.LeaderNode()
+		h["nodes"] = service.ClusterServiceIns().Nodes()
+		if global.Cfg().IsSharding() {
+			h["tables"] = service.TransferServiceIns().ShardTables()
+		}
+	}
+	c.JSON(http.StatusOK, h)
+}
+
+func positionFunc(c *gin.Context) {
+	pos, err := service.TransferServiceIns().Position()
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": pos.Name, "pos": pos.Pos})
+}
+
+// setPositionFunc 请求体：{"name": "mysql-bin.000001", "pos": 4}
+func setPositionFunc(c *gin.Context) {
+	var pos mysql.Position
+	if err := c.ShouldBindJSON(&pos); err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	if pos.Name == "" || pos.Pos == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and pos required"})
+		return
+	}
+	if err := service.TransferServiceIns().SetPosition(pos); err != nil {
+		apiError(c, http.StatusConflict, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": pos.Name, "pos": pos.Pos})
+}
+
+func rulesFunc(c *gin.Context) {
+	rules := make([]gin.H, 0)
+	for _, key := range global.RuleKeyList() {
+		rule, ok := global.RuleIns(key)
+		if !ok {
+			continue
+		}
+		rules = append(rules, gin.H{
+			"key":          key,
+			"schema":       rule.Schema,
+			"table":        rule.Table,
+			"lua":          rule.LuaEnable(),
+			"insertAmount": metrics.LabInsertAmount(key),
+			"updateAmount": metrics.LabUpdateRecord(key),
+			"deleteAmount": metrics.LabDeleteRecord(key),
+		})
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func reloadRulesFunc(c *gin.Context) {
+	if err := service.ReloadRules(); err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": global.RuleKeyList()})
+}
+
+func ruleVersionsFunc(c *gin.Context) {
+	versions, current, err := service.RuleSetVersions()
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"versions": versions,
+		"current":  current,
+		"applied":  service.TransferServiceIns().RuleVersion(),
+	})
+}
+
+func ruleSetFunc(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	data, err := service.RuleSet(version)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/x-yaml", data)
+}
+
+// publishRulesFunc 请求体为YAML格式的规则集
+func publishRulesFunc(c *gin.Context) {
+	data, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	version, err := service.PublishRuleSet(data)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": version})
+}
+
+// rollbackRulesFunc 参数version为空时回滚到当前版本的上一个版本
+func rollbackRulesFunc(c *gin.Context) {
+	version := 0
+	if v := c.Query("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+			return
+		}
+	}
+	version, err := service.RollbackRuleSet(version)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": version})
+}
+
+func pauseFunc(c *gin.Context) {
+	service.TransferServiceIns().Pause()
+	c.JSON(http.StatusOK, gin.H{"paused": true})
+}
+
+func resumeFunc(c *gin.Context) {
+	service.TransferServiceIns().Resume()
+	c.JSON(http.StatusOK, gin.H{"paused": false})
+}
+
+func stockStatusFunc(c *gin.Context) {
+	status := service.StockStatus()
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no stock import"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// stockFunc 请求体：{"tables": ["schema.table"]}，后台导入，通过GET /api/stock查看进度
+func stockFunc(c *gin.Context) {
+	var req struct {
+		Tables []string `json:"tables"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	for i, table := range req.Tables {
+		req.Tables[i] = strings.TrimSpace(table)
+	}
+	if err := service.StartStock(req.Tables); err != nil {
+		apiError(c, http.StatusConflict, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, service.StockStatus())
+}
@@ -0,0 +1,39 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go-mysql-transfer/global"
+	"go-mysql-transfer/service"
+)
+
+// RegisterHealth 启用prometheus exporter时，在exporter端口上同样提供健康检查接口
+func RegisterHealth() {
+	if global.Cfg().EnableExporter {
+		http.HandleFunc("/healthz", healthzFunc)
+		http.HandleFunc("/readyz", readyzFunc)
+	}
+}
+
+// healthzFunc 存活检查，失败时返回503
+func healthzFunc(w http.ResponseWriter, _ *http.Request) {
+	h := service.CheckHealth()
+	writeHealth(w, h.Live, h)
+}
+
+// readyzFunc 就绪检查，失败时返回503
+func readyzFunc(w http.ResponseWriter, _ *http.Request) {
+	h := service.CheckHealth()
+	writeHealth(w, h.Ready, h)
+}
+
+func writeHealth(w http.ResponseWriter, ok bool, h *service.HealthStatus) {
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,72 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-mysql-transfer/service/pipeline"
+	"go-mysql-transfer/util/logs"
+	"go-mysql-transfer/util/nets"
+)
+
+// StartPipelineAdmin 多管道模式下的管理接口，列出管道状态，启动、停止、重启管道及重新加载管道配置文件
+func StartPipelineAdmin(m *pipeline.Manager) error {
+	if !m.Config().EnableWebAdmin {
+		return nil
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	g := gin.New()
+	g.Use(tokenAuth(m.Config().WebAdminToken))
+	g.GET("/pipelines", func(c *gin.Context) {
+		c.JSON(http.StatusOK, m.Statuses())
+	})
+	g.POST("/reload", func(c *gin.Context) {
+		pipelineResult(c, m.Reload())
+	})
+	g.POST("/pipelines/:name/start", func(c *gin.Context) {
+		pipelineResult(c, m.StartPipeline(c.Param("name")))
+	})
+	g.POST("/pipelines/:name/stop", func(c *gin.Context) {
+		pipelineResult(c, m.StopPipeline(c.Param("name")))
+	})
+	g.POST("/pipelines/:name/restart", func(c *gin.Context) {
+		pipelineResult(c, m.RestartPipeline(c.Param("name")))
+	})
+
+	listen := fmt.Sprintf(":%s", strconv.Itoa(m.Config().WebAdminPort))
+	_server = &http.Server{
+		Addr:           listen,
+		Handler:        g,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   time.Duration(m.Config().StopTimeout+10) * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	ok, err := nets.IsUsableTcpAddr(listen)
+	if !ok {
+		return err
+	}
+
+	log.Println(fmt.Sprintf("Pipeline Admin Listen At %s", listen))
+	go func() {
+		if err := _server.ListenAndServe(); err != nil {
+			logs.Error(err.Error())
+		}
+	}()
+
+	return nil
+}
+
+func pipelineResult(c *gin.Context, err error) {
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
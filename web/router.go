@@ -1,6 +1,7 @@
 package web
 
 import (
+	"context"
 	"fmt"
 	"go-mysql-transfer/service"
 	"go-mysql-transfer/util/dates"
@@ -35,14 +36,19 @@ func Start() error {
 	g.Static("/statics", statics)
 	g.LoadHTMLFiles(index)
 	g.GET("/", webAdminFunc)
+	g.GET("/healthz", gin.WrapF(healthzFunc))
+	g.GET("/readyz", gin.WrapF(readyzFunc))
+	registerApi(g)
 
 	port := global.Cfg().WebAdminPort
 	listen := fmt.Sprintf(":%s", strconv.Itoa(port))
+	// 暂停、设置位置、重载规则需等待当前事务结束(最长DrainTimeout)并关闭canal后才应答
+	writeTimeout := time.Duration(service.DrainTimeout+30) * time.Second
 	_server = &http.Server{
 		Addr:           listen,
 		Handler:        g,
 		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
+		WriteTimeout:   writeTimeout,
 		MaxHeaderBytes: 1 << 20,
 	}
 
@@ -130,7 +136,7 @@ func Close() {
 		return
 	}
 
-	err := _server.Shutdown(nil)
+	err := _server.Shutdown(context.Background())
 	if err != nil {
 		log.Println(err.Error())
 	}